@@ -0,0 +1,153 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/fatih/color"
+	"github.com/quiknode-labs/qn-marketplace-cli/marketplace"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/cobra"
+)
+
+// accessProbe makes the RPC and REST calls an endpoint's users would make,
+// with the same instance headers as the rpc and rest commands, to check
+// whether the endpoint currently has access to the add-on.
+type accessProbe struct {
+	client    *marketplace.Client
+	instance  marketplace.InstanceHeaders
+	label     string
+	verbose   bool
+	rpcURL    string
+	rpcMethod string
+	rpcParams []interface{}
+	restURL   string
+	restVerb  string
+	restBody  string
+}
+
+// addAccessFlags adds the --rpc-* and --rest-* flags used to build an accessProbe.
+func addAccessFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("rpc-url", "", "The URL to make RPC calls to after each step, to check access is enforced (optional)")
+	cmd.PersistentFlags().String("rpc-method", "", "The RPC Method to call when checking access")
+	cmd.PersistentFlags().String("rpc-params", "", "The RPC Params to call the RPC Method with in JSON format")
+	cmd.PersistentFlags().String("rest-url", "", "The URL to make REST calls to after each step, to check access is enforced (optional)")
+	cmd.PersistentFlags().String("rest-verb", "GET", "The REST HTTP Method or verb to use when checking access (e.g. GET or POST)")
+	cmd.PersistentFlags().String("rest-body", "", "The Rest Request Body to send when checking access")
+}
+
+// newAccessProbe builds the probe for the endpoint with the given instance
+// headers from the command's --rpc-* and --rest-* flags, returning nil when
+// neither --rpc-url nor --rest-url is set.
+func newAccessProbe(cmd *cobra.Command, client *marketplace.Client, instance marketplace.InstanceHeaders) (*accessProbe, error) {
+	p := &accessProbe{
+		client:    client,
+		instance:  instance,
+		verbose:   cmd.Flag("verbose").Value.String() == "true",
+		rpcURL:    cmd.Flag("rpc-url").Value.String(),
+		rpcMethod: cmd.Flag("rpc-method").Value.String(),
+		restURL:   cmd.Flag("rest-url").Value.String(),
+		restVerb:  cmd.Flag("rest-verb").Value.String(),
+		restBody:  cmd.Flag("rest-body").Value.String(),
+	}
+	if p.rpcURL == "" && p.restURL == "" {
+		return nil, nil
+	}
+
+	if p.rpcURL != "" {
+		if p.rpcMethod == "" {
+			return nil, errors.New("--rpc-url also needs the RPC method to check access with, via the --rpc-method flag")
+		}
+		p.rpcParams = []interface{}{}
+		if params := cmd.Flag("rpc-params").Value.String(); params != "" {
+			if err := json.Unmarshal([]byte(params), &p.rpcParams); err != nil {
+				return nil, fmt.Errorf("error parsing --rpc-params: %w", err)
+			}
+		}
+	}
+	return p, nil
+}
+
+// forEndpoint returns a probe that makes the same calls as another endpoint
+// of the account, naming it with label in the checks.
+func (p *accessProbe) forEndpoint(label string, endpointId string) *accessProbe {
+	if p == nil {
+		return nil
+	}
+	probe := *p
+	probe.label = label
+	probe.instance.EndpointId = endpointId
+	return &probe
+}
+
+// check records whether the RPC and REST calls are served after the given
+// lifecycle step when allowed is set, or refused with a 4xx when it isn't.
+// A nil probe checks nothing.
+func (p *accessProbe) check(ctx context.Context, results *results, keepGoing bool, step string, allowed bool) {
+	if p == nil {
+		return
+	}
+	if p.rpcURL != "" {
+		results.run(accessCheckName("RPC", p.label, step, allowed), keepGoing, func() error {
+			request := marketplace.RPCRequest{Method: p.rpcMethod, Params: p.rpcParams, ID: uuid.NewV4().String()}
+			if p.verbose {
+				requestJson, _ := json.MarshalIndent(request, "", "  ")
+				color.Blue("\n→ POST %s (after %s):\n", p.rpcURL, step)
+				fmt.Printf("%s\n", requestJson)
+			}
+			statusCode, _, err := p.client.RPC(ctx, p.rpcURL, request, p.instance)
+			return accessError(statusCode, err, step, allowed)
+		})
+	}
+	if p.restURL != "" {
+		results.run(accessCheckName("REST", p.label, step, allowed), keepGoing, func() error {
+			if p.verbose {
+				color.Blue("\n→ %s %s (after %s):\n", p.restVerb, p.restURL, step)
+				fmt.Printf("%s\n", p.restBody)
+			}
+			statusCode, _, err := p.client.REST(ctx, p.restVerb, p.restURL, p.restBody, p.instance)
+			return accessError(statusCode, err, step, allowed)
+		})
+	}
+}
+
+func accessCheckName(api string, label string, step string, allowed bool) string {
+	name := api + " access"
+	if label != "" {
+		name += " for " + label
+	}
+	if allowed {
+		return fmt.Sprintf("%s works after %s", name, step)
+	}
+	return fmt.Sprintf("%s is refused after %s", name, step)
+}
+
+// accessError checks the outcome of an RPC or REST call. Refusals don't need
+// a JSON body, but calls that are served do.
+func accessError(statusCode int, err error, step string, allowed bool) error {
+	var decodeErr *marketplace.DecodeError
+	if err != nil && !(errors.As(err, &decodeErr) && !allowed) {
+		return err
+	}
+
+	if allowed {
+		if statusCode != http.StatusOK {
+			return fmt.Errorf("the add-on responded with %d %s after %s", statusCode, http.StatusText(statusCode), step)
+		}
+		return nil
+	}
+	switch {
+	case statusCode >= 400 && statusCode < 500:
+		return nil
+	case statusCode >= 500:
+		return fmt.Errorf("the add-on crashed with %d %s after %s instead of refusing with a 4xx", statusCode, http.StatusText(statusCode), step)
+	default:
+		return fmt.Errorf("the add-on still served the call with %d %s after %s", statusCode, http.StatusText(statusCode), step)
+	}
+}
@@ -0,0 +1,296 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/quiknode-labs/qn-marketplace-cli/marketplace"
+	"github.com/spf13/cobra"
+)
+
+// cleanupCmd represents the cleanup command
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup [name|quicknode-id|endpoint-id...]",
+	Short: "Deactivates and deprovisions the add-on instances provisioned by the CLI",
+	Long: `Deactivates the endpoints and deprovisions the accounts of every instance recorded in the
+state file that isn't deprovisioned yet (see the instances command), or only of the given ones.
+Instances the add-on no longer knows about (404 or 410) count as cleaned up.
+
+The deactivate_endpoint and deprovision URLs are built from --base-url and the route flags like
+the pudd command does or, without --base-url, from the URL each instance was provisioned with,
+replacing its trailing /provision with /deactivate_endpoint and /deprovision.
+
+Commands that provision instances (pudd, rpc, rest and sso) can also clean up after themselves
+with --cleanup, even when a check fails or they are interrupted with Ctrl-C.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		printHeader("CLEANUP")
+		dryRun := cmd.Flag("dry-run").Value.String() == "true"
+		store, err := openInstanceStore(cmd)
+		if err != nil {
+			color.Red("%s", err)
+			os.Exit(1)
+		}
+
+		var instances []*instance
+		if len(args) == 0 {
+			for _, inst := range store.Instances {
+				if inst.Status != instanceDeprovisioned {
+					instances = append(instances, inst)
+				}
+			}
+		}
+		for _, ref := range args {
+			inst, err := store.find(ref)
+			if err != nil {
+				color.Red("%s", err)
+				os.Exit(1)
+			}
+			instances = append(instances, inst)
+		}
+		if len(instances) == 0 {
+			fmt.Printf("Nothing to clean up in %s\n", store.path)
+			return
+		}
+		if dryRun {
+			for _, inst := range instances {
+				fmt.Printf("  Would clean up endpoint %s of account %s (%s)\n", inst.EndpointId, inst.QuickNodeId, inst.Status)
+			}
+			return
+		}
+
+		results := newResults(cmd, "cleanup")
+		var options []marketplace.ClientOption
+		baseUrl := cmd.Flag("base-url").Value.String()
+		if baseUrl != "" {
+			options = append(options, marketplace.WithBaseURL(baseUrl))
+		}
+		client := newClient(cmd, append(options, results.recording())...)
+		c := &cleaner{cmd: cmd, client: client, results: results, verbose: cmd.Flag("verbose").Value.String() == "true"}
+		if baseUrl != "" {
+			if c.routes, err = newRoutes(cmd, client); err != nil {
+				color.Red("%s", err)
+				os.Exit(1)
+			}
+		}
+		c.cleanAll(context.Background(), instances)
+		results.finish()
+	},
+}
+
+// cleaner deactivates and deprovisions instances, either those recorded in the
+// state file or those a command provisioned during its run.
+type cleaner struct {
+	cmd     *cobra.Command
+	client  *marketplace.Client
+	results *results
+	verbose bool
+
+	// routes builds the URLs of the actions when there's a base URL, otherwise
+	// they are derived from the URL each instance was provisioned with.
+	routes *routes
+
+	pending []*instance
+}
+
+// addCleanupFlags adds --cleanup to a command that provisions instances. Commands
+// that don't have route flags also get the URLs to clean up with.
+func addCleanupFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().Bool("cleanup", false, "Deactivate and deprovision the instances the command provisioned once it finishes, even when a check fails or it is interrupted")
+	if cmd.PersistentFlags().Lookup("deactivate-url") == nil {
+		cmd.PersistentFlags().String("deactivate-url", "", "The URL of the add-on's deactivate_endpoint action for --cleanup (defaults to --url with its trailing /provision replaced)")
+		cmd.PersistentFlags().String("deprovision-url", "", "The URL of the add-on's deprovision action for --cleanup (defaults to --url with its trailing /provision replaced)")
+	}
+}
+
+// newRunCleanup returns the cleaner of the instances a command provisions when
+// it is called with --cleanup, or nil. The instances left are cleaned up when
+// the command finishes.
+func newRunCleanup(cmd *cobra.Command, client *marketplace.Client, results *results, routes *routes) *cleaner {
+	if cmd.Flag("cleanup").Value.String() != "true" {
+		return nil
+	}
+	c := &cleaner{cmd: cmd, client: client, results: results, routes: routes, verbose: cmd.Flag("verbose").Value.String() == "true"}
+	if routes == nil {
+		// Better to find out now than after having provisioned
+		provisioned := &instance{ProvisionURL: cmd.Flag("url").Value.String()}
+		for _, action := range []string{"deactivate_endpoint", "deprovision"} {
+			if _, err := c.url(action, provisioned); err != nil {
+				color.Red("%s", err)
+				os.Exit(1)
+			}
+		}
+	}
+	results.onFinish(c.clean)
+	return c
+}
+
+// track adds an instance to clean up. It is called before provisioning, since
+// a provision that timed out or was interrupted may still have created it.
+func (c *cleaner) track(provisionURL string, request marketplace.ProvisionRequest) {
+	if c == nil {
+		return
+	}
+	for _, inst := range c.pending {
+		if inst.QuickNodeId == request.QuickNodeId && inst.EndpointId == request.EndpointId {
+			return
+		}
+	}
+	c.pending = append(c.pending, newInstance(provisionURL, request))
+}
+
+// deactivated notes that the command deactivated an endpoint itself.
+func (c *cleaner) deactivated(quicknodeId string, endpointId string) {
+	if c == nil {
+		return
+	}
+	for _, inst := range c.pending {
+		if inst.QuickNodeId == quicknodeId && inst.EndpointId == endpointId {
+			inst.Status = instanceDeactivated
+		}
+	}
+}
+
+// deprovisioned notes that the command deprovisioned an account itself.
+func (c *cleaner) deprovisioned(quicknodeId string) {
+	if c == nil {
+		return
+	}
+	var pending []*instance
+	for _, inst := range c.pending {
+		if inst.QuickNodeId != quicknodeId {
+			pending = append(pending, inst)
+		}
+	}
+	c.pending = pending
+}
+
+// clean cleans up the instances left. It doesn't use the command's context,
+// which is done once the deadline passed or the command was interrupted.
+func (c *cleaner) clean() {
+	if c == nil || len(c.pending) == 0 {
+		return
+	}
+	pending := c.pending
+	c.pending = nil
+	if outputFormat == outputText {
+		fmt.Println()
+		printHeader("CLEANUP")
+	}
+	c.cleanAll(context.Background(), pending)
+}
+
+// cleanAll deactivates the endpoints of each account, then deprovisions it.
+func (c *cleaner) cleanAll(ctx context.Context, instances []*instance) {
+	var accounts []string
+	endpoints := map[string][]*instance{}
+	for _, inst := range instances {
+		if _, ok := endpoints[inst.QuickNodeId]; !ok {
+			accounts = append(accounts, inst.QuickNodeId)
+		}
+		endpoints[inst.QuickNodeId] = append(endpoints[inst.QuickNodeId], inst)
+	}
+
+	for _, quicknodeId := range accounts {
+		for _, inst := range endpoints[quicknodeId] {
+			if inst.Status == instanceDeactivated {
+				continue
+			}
+			inst := inst
+			c.results.run(fmt.Sprintf("Endpoint %s was deactivated", inst.EndpointId), true, func() error {
+				deactivateUrl, err := c.url("deactivate_endpoint", inst)
+				if err != nil {
+					return err
+				}
+				if c.verbose {
+					color.Blue("→ DELETE %s", deactivateUrl)
+				}
+				_, err = c.client.Deactivate(ctx, deactivateUrl, marketplace.DeactivateRequest{
+					QuickNodeId:  inst.QuickNodeId,
+					EndpointId:   inst.EndpointId,
+					Chain:        inst.Chain,
+					Network:      inst.Network,
+					DeactivateAt: time.Now().Unix(),
+					AddOnId:      inst.AddOnId,
+					AddOnSlug:    inst.AddOnSlug,
+				})
+				if err != nil && !alreadyGone(err) {
+					return err
+				}
+				recordStatus(c.cmd, inst.QuickNodeId, inst.EndpointId, instanceDeactivated, "")
+				return nil
+			})
+		}
+
+		inst := endpoints[quicknodeId][0]
+		c.results.run(fmt.Sprintf("Account %s was deprovisioned", quicknodeId), true, func() error {
+			deprovisionUrl, err := c.url("deprovision", inst)
+			if err != nil {
+				return err
+			}
+			if c.verbose {
+				color.Blue("→ DELETE %s", deprovisionUrl)
+			}
+			_, err = c.client.Deprovision(ctx, deprovisionUrl, marketplace.DeprovisionRequest{
+				QuickNodeId: inst.QuickNodeId,
+				AddOnId:     inst.AddOnId,
+				AddOnSlug:   inst.AddOnSlug,
+			})
+			if err != nil && !alreadyGone(err) {
+				return err
+			}
+			recordStatus(c.cmd, inst.QuickNodeId, "", instanceDeprovisioned, "")
+			return nil
+		})
+	}
+}
+
+// url returns the URL of the deactivate_endpoint or deprovision action for an instance.
+func (c *cleaner) url(action string, inst *instance) (string, error) {
+	if c.routes != nil {
+		r := *c.routes
+		r.chain, r.network = inst.Chain, inst.Network
+		return r.url(action, inst.QuickNodeId, inst.EndpointId), nil
+	}
+
+	flag := "deprovision-url"
+	if action == "deactivate_endpoint" {
+		flag = "deactivate-url"
+	}
+	if f := c.cmd.Flag(flag); f != nil && f.Value.String() != "" {
+		return f.Value.String(), nil
+	}
+	u, err := url.Parse(inst.ProvisionURL)
+	if err != nil || !strings.HasSuffix(u.Path, "/provision") {
+		return "", fmt.Errorf("can't tell the %s URL from the provision URL %q, please provide it via the --%s flag", action, inst.ProvisionURL, flag)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "provision") + action
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// alreadyGone reports whether the add-on answered that the instance doesn't exist.
+func alreadyGone(err error) bool {
+	var httpErr *marketplace.HTTPError
+	return errors.As(err, &httpErr) && (httpErr.StatusCode == http.StatusNotFound || httpErr.StatusCode == http.StatusGone)
+}
+
+func init() {
+	rootCmd.AddCommand(cleanupCmd)
+
+	cleanupCmd.PersistentFlags().StringP("base-url", "u", "", "The base URL of the add-on's provisioning API (defaults to the URL each instance was provisioned with)")
+
+	// Note: basic auth defaults to username = Aladdin and password = open sesame
+	cleanupCmd.PersistentFlags().String("basic-auth", "QWxhZGRpbjpvcGVuIHNlc2FtZQ==", "The basic auth credentials for the add-on. Defaults to username = Aladdin and password = open sesame")
+	cleanupCmd.PersistentFlags().Bool("dry-run", false, "Only list the instances that would be cleaned up")
+	addRouteFlags(cleanupCmd)
+}
@@ -0,0 +1,71 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	neturl "net/url"
+	"strings"
+
+	"github.com/quiknode-labs/qn-marketplace-cli/marketplace"
+	"github.com/spf13/cobra"
+)
+
+// newClient builds the marketplace client shared by all the steps of a command,
+// using the command's --basic-auth flag (if it has one) and the global --timeout.
+func newClient(cmd *cobra.Command, opts ...marketplace.ClientOption) *marketplace.Client {
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	options := []marketplace.ClientOption{marketplace.WithTimeout(timeout)}
+	if flag := cmd.Flag("basic-auth"); flag != nil {
+		options = append(options, marketplace.WithBasicAuth(flag.Value.String()))
+	}
+	return marketplace.NewClient(append(options, opts...)...)
+}
+
+// withToken returns the dashboard URL with the token as its jwt parameter,
+// keeping the parameters the URL already has.
+func withToken(dashboardURL string, token string) (string, error) {
+	url, err := neturl.Parse(dashboardURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid dashboard-url %q: %w", dashboardURL, err)
+	}
+	query := url.Query()
+	query.Set("jwt", token)
+	url.RawQuery = query.Encode()
+	return url.String(), nil
+}
+
+// commandContext returns the context for a command's run, bounded by the global --deadline.
+func commandContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	ctx := cmd.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	deadline, _ := cmd.Flags().GetDuration("deadline")
+	if deadline > 0 {
+		return context.WithTimeout(ctx, deadline)
+	}
+	return context.WithCancel(ctx)
+}
+
+// checkBasicAuth returns an error unless the provisioning API at url rejects
+// requests made without credentials as well as every wrong credential variant
+// with a 401 or 403, naming each variant that slipped through.
+func checkBasicAuth(ctx context.Context, client *marketplace.Client, url string, httpMethod string, api string) error {
+	var accepted []string
+	for _, variant := range client.CredentialVariants() {
+		rejected, statusCode, err := client.RejectsCredentials(ctx, url, httpMethod, variant)
+		if err != nil {
+			return fmt.Errorf("%s: %w", variant.Name, err)
+		}
+		if !rejected {
+			accepted = append(accepted, fmt.Sprintf("%s (status code %d)", variant.Name, statusCode))
+		}
+	}
+	if len(accepted) > 0 {
+		return fmt.Errorf("the %s API did not reject with a 401 or 403: %s", api, strings.Join(accepted, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// configFileName is the project config file looked up from the working directory upwards.
+const configFileName = ".qn-marketplace.yaml"
+
+// configAnnotation marks the flags set by applyConfig rather than on the command
+// line, which values picked by the command itself such as --instance override.
+const configAnnotation = "qn-marketplace-cli/config"
+
+// envPrefix prefixes the environment variables that set flags, e.g. QN_BASIC_AUTH for --basic-auth.
+const envPrefix = "QN_"
+
+// configFile is a .qn-marketplace.yaml project file. Defaults and profiles map
+// flag names to values, which can be scalars, lists for repeatable flags, or
+// mappings for key=value flags such as matrix and report. They can also map a
+// command, e.g. provision or "jwt mint", to a section of flags that only apply
+// to it and its subcommands.
+type configFile struct {
+	DefaultProfile string                          `yaml:"default-profile"`
+	Defaults       map[string]yaml.Node            `yaml:"defaults"`
+	Profiles       map[string]map[string]yaml.Node `yaml:"profiles"`
+}
+
+// findConfigFile returns the path of the closest config file in dir or one of
+// its parents, or "" if there is none.
+func findConfigFile(dir string) string {
+	for {
+		path := filepath.Join(dir, configFileName)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+func loadConfigFile(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config configFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// applyConfig sets the flags the command was not called with from QN_*
+// environment variables, then from the selected profile of the config file,
+// then from its defaults. Flags always win over the environment, which wins
+// over the config file.
+func applyConfig(cmd *cobra.Command) error {
+	if noConfig, _ := cmd.Flags().GetBool("no-config"); noConfig {
+		return nil
+	}
+
+	values, err := configValues(cmd)
+	if err != nil {
+		return err
+	}
+
+	var applyErr error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if f.Changed || applyErr != nil {
+			return
+		}
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			applyErr = setFlag(cmd, f.Name, []string{value}, "$"+envName(f.Name))
+		} else if value, ok := values[f.Name]; ok {
+			applyErr = setFlag(cmd, f.Name, value, "config "+f.Name)
+		}
+	})
+	return applyErr
+}
+
+// configValues returns the values of the config file's defaults merged with
+// those of the selected profile, keyed by flag name.
+func configValues(cmd *cobra.Command) (map[string][]string, error) {
+	path := cmd.Flag("config").Value.String()
+	if path == "" {
+		path = os.Getenv(envName("config"))
+	}
+	if path == "" {
+		dir, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		if path = findConfigFile(dir); path == "" {
+			return nil, nil
+		}
+	}
+	config, err := loadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := cmd.Flag("profile").Value.String()
+	if profile == "" {
+		profile = os.Getenv(envName("profile"))
+	}
+	if profile == "" {
+		profile = config.DefaultProfile
+	}
+	sections := []map[string]yaml.Node{config.Defaults}
+	if profile != "" {
+		section, ok := config.Profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown profile %q, expected one of: %s", path, profile, strings.Join(profileNames(config), ", "))
+		}
+		sections = append(sections, section)
+	}
+
+	known := knownFlags(cmd.Root())
+	commands := commandPaths(cmd.Root())
+	values := map[string][]string{}
+	for _, section := range sections {
+		flags := map[string]yaml.Node{}
+		commandSections := map[string]map[string]yaml.Node{}
+		for name, node := range section {
+			command, ok := commands[name]
+			if !ok || node.Kind != yaml.MappingNode {
+				flags[name] = node
+				continue
+			}
+			var commandSection map[string]yaml.Node
+			if err := node.Decode(&commandSection); err != nil {
+				return nil, fmt.Errorf("%s:%d: %s: %w", path, node.Line, name, err)
+			}
+			// Sections of other commands are checked too, but not applied
+			if err := addConfigValues(map[string][]string{}, path, commandSection, commandFlags(command), "the "+name+" command"); err != nil {
+				return nil, err
+			}
+			commandSections[name] = commandSection
+		}
+		if err := addConfigValues(values, path, flags, known, "any command"); err != nil {
+			return nil, err
+		}
+		// The sections of the command and its parents win over the rest of
+		// the section, the command's own one last
+		for _, name := range commandLineage(cmd) {
+			if err := addConfigValues(values, path, commandSections[name], known, "any command"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return values, nil
+}
+
+// addConfigValues adds the values of a section's flags to values, checking
+// that they are flags of the commands described by of.
+func addConfigValues(values map[string][]string, path string, section map[string]yaml.Node, known map[string]bool, of string) error {
+	for name, node := range section {
+		if !known[name] || name == "config" || name == "profile" || name == "no-config" {
+			return fmt.Errorf("%s:%d: %q is not a flag of %s", path, node.Line, name, of)
+		}
+		value, err := configValue(node)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", path, node.Line, name, err)
+		}
+		values[name] = value
+	}
+	return nil
+}
+
+// configValue turns a config value into the values to set its flag with:
+// scalars are set as is, each item of a list is set in turn, and each entry
+// of a mapping is set as key=value, with lists joined by commas.
+func configValue(node yaml.Node) ([]string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return []string{node.Value}, nil
+	case yaml.SequenceNode:
+		var values []string
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, errors.New("lists can only hold plain values")
+			}
+			values = append(values, item.Value)
+		}
+		return values, nil
+	case yaml.MappingNode:
+		var values []string
+		for i := 0; i < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if value.Kind == yaml.MappingNode {
+				return nil, errors.New("mappings can only hold plain values or lists")
+			}
+			items, err := configValue(*value)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, key.Value+"="+strings.Join(items, ","))
+		}
+		return values, nil
+	default:
+		return nil, errors.New("unsupported value")
+	}
+}
+
+func setFlag(cmd *cobra.Command, name string, values []string, source string) error {
+	if len(values) == 0 {
+		// An empty list sets a repeatable flag to "", which sends an empty list
+		values = []string{""}
+	}
+	for _, value := range values {
+		if err := cmd.Flags().Set(name, value); err != nil {
+			return fmt.Errorf("invalid %s %q: %w", source, value, err)
+		}
+	}
+	return cmd.Flags().SetAnnotation(name, configAnnotation, []string{source})
+}
+
+// setOnCommandLine reports whether the command was called with the flag, as
+// opposed to it being set from the environment or the config file.
+func setOnCommandLine(f *pflag.Flag) bool {
+	_, fromConfig := f.Annotations[configAnnotation]
+	return f.Changed && !fromConfig
+}
+
+// envName returns the environment variable for a flag, e.g. QN_BASIC_AUTH for basic-auth.
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// knownFlags returns the names of the flags of every command, so that config
+// files shared by several commands can set flags only some of them have.
+func knownFlags(cmd *cobra.Command) map[string]bool {
+	known := map[string]bool{}
+	cmd.Flags().VisitAll(func(f *pflag.Flag) { known[f.Name] = true })
+	cmd.PersistentFlags().VisitAll(func(f *pflag.Flag) { known[f.Name] = true })
+	for _, child := range cmd.Commands() {
+		for name := range knownFlags(child) {
+			known[name] = true
+		}
+	}
+	return known
+}
+
+// commandPath returns the path of a command below the root one, e.g. "jwt mint".
+func commandPath(cmd *cobra.Command) string {
+	return strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" ")
+}
+
+// commandPaths returns every command below the root one, by path.
+func commandPaths(cmd *cobra.Command) map[string]*cobra.Command {
+	commands := map[string]*cobra.Command{}
+	for _, child := range cmd.Commands() {
+		commands[commandPath(child)] = child
+		for path, command := range commandPaths(child) {
+			commands[path] = command
+		}
+	}
+	return commands
+}
+
+// commandLineage returns the paths of a command's parents below the root
+// one and its own, from the top-level command down.
+func commandLineage(cmd *cobra.Command) []string {
+	var paths []string
+	for c := cmd; c.HasParent(); c = c.Parent() {
+		paths = append([]string{commandPath(c)}, paths...)
+	}
+	return paths
+}
+
+// commandFlags returns the names of the flags a command's section can set:
+// its own, those it inherits and those of its subcommands.
+func commandFlags(cmd *cobra.Command) map[string]bool {
+	known := knownFlags(cmd)
+	cmd.InheritedFlags().VisitAll(func(f *pflag.Flag) { known[f.Name] = true })
+	return known
+}
+
+func profileNames(config *configFile) []string {
+	var names []string
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,129 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+const testConfig = `
+default-profile: staging
+defaults:
+  chain: polygon
+  network: testnet
+  basic-auth: default
+  provision:
+    network: goerli
+profiles:
+  staging:
+    basic-auth: staging
+    plan-methods: [discover=eth_call, build=trace_block]
+    matrix:
+      chain: [ethereum, polygon]
+      network: mainnet
+  prod:
+    network: sepolia
+    provision:
+      chain: solana
+`
+
+func TestApplyConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), configFileName)
+	if err := os.WriteFile(path, []byte(testConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want map[string]string
+	}{
+		{
+			name: "no config",
+			args: []string{"--no-config"},
+			want: map[string]string{"chain": "ethereum", "network": "mainnet", "basic-auth": "", "plan-methods": "[]", "matrix": "[]"},
+		},
+		{
+			name: "default profile",
+			want: map[string]string{
+				"chain":        "polygon",
+				"network":      "goerli",
+				"basic-auth":   "staging",
+				"plan-methods": "[discover=eth_call,build=trace_block]",
+				"matrix":       `["chain=ethereum,polygon",network=mainnet]`,
+			},
+		},
+		{
+			name: "profile flag",
+			args: []string{"--profile", "prod"},
+			want: map[string]string{"chain": "solana", "network": "sepolia", "basic-auth": "default", "plan-methods": "[]"},
+		},
+		{
+			name: "profile variable",
+			env:  map[string]string{"QN_PROFILE": "prod"},
+			want: map[string]string{"chain": "solana", "network": "sepolia"},
+		},
+		{
+			name: "variables beat the config file",
+			env:  map[string]string{"QN_BASIC_AUTH": "env", "QN_NETWORK": "devnet", "QN_PLAN_METHODS": "scale=eth_subscribe"},
+			want: map[string]string{"chain": "polygon", "network": "devnet", "basic-auth": "env", "plan-methods": "[scale=eth_subscribe]"},
+		},
+		{
+			name: "flags beat variables and the config file",
+			args: []string{"--basic-auth", "flag", "--matrix", "chain=solana", "--plan-methods", "scale=eth_subscribe", "--plan-methods", "build=eth_call"},
+			env:  map[string]string{"QN_BASIC_AUTH": "env"},
+			want: map[string]string{
+				"chain":        "polygon",
+				"basic-auth":   "flag",
+				"plan-methods": "[scale=eth_subscribe,build=eth_call]",
+				"matrix":       "[chain=solana]",
+			},
+		},
+		{
+			name: "variables are ignored without the config file",
+			args: []string{"--no-config"},
+			env:  map[string]string{"QN_BASIC_AUTH": "env"},
+			want: map[string]string{"basic-auth": ""},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+
+			root := &cobra.Command{Use: "qn"}
+			root.PersistentFlags().String("config", "", "")
+			root.PersistentFlags().String("profile", "", "")
+			root.PersistentFlags().Bool("no-config", false, "")
+			provision := &cobra.Command{
+				Use: "provision",
+				RunE: func(cmd *cobra.Command, args []string) error {
+					return applyConfig(cmd)
+				},
+			}
+			provision.Flags().String("chain", "ethereum", "")
+			provision.Flags().String("network", "mainnet", "")
+			provision.Flags().String("basic-auth", "", "")
+			provision.Flags().StringArray("plan-methods", []string{}, "")
+			provision.Flags().StringArray("matrix", []string{}, "")
+			root.AddCommand(provision)
+
+			root.SetArgs(append([]string{"provision", "--config", path}, test.args...))
+			if err := root.Execute(); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range test.want {
+				if got := provision.Flag(name).Value.String(); got != want {
+					t.Errorf("%s: got %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/quiknode-labs/qn-marketplace-cli/marketplace"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/cobra"
+)
+
+// conformanceCmd represents the conformance command
+var conformanceCmd = &cobra.Command{
+	Use:   "conformance",
+	Short: "Allows you to test how your add-on handles calls outside of the happy path",
+}
+
+// conformanceLifecycleCmd represents the conformance lifecycle command
+var conformanceLifecycleCmd = &cobra.Command{
+	Use:   "lifecycle",
+	Short: "Sends provisioning calls out of order and checks your add-on handles them gracefully",
+	Long: `Use this command to make sure your provisioning API copes with calls that arrive out of order
+or refer to accounts and endpoints it doesn't know about, which happens when QuickNode retries calls.
+
+Each case must get one of the documented statuses below, and never a 5xx:
+  - update before provision: 400, 404, 409, 410 or 422
+  - deactivate an unknown endpoint-id: 200 or 404
+  - update a deactivated endpoint: 200, 400, 404, 409, 410 or 422
+  - deprovision an unknown quicknode-id: 200 or 404
+  - provision after deprovision: 200
+
+It uses the same base URL and routes as pudd, with fresh QuickNode and endpoint IDs for every run.
+`,
+	Args: cobra.OnlyValidArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		printHeader("CONFORMANCE: LIFECYCLE")
+		baseUrl := cmd.Flag("base-url").Value.String()
+		if baseUrl == "" {
+			fmt.Print("Please provide a base URL for the provisioning API via the --base-url flag\n")
+			os.Exit(1)
+		}
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		results := newResults(cmd, "conformance-lifecycle")
+		client := newClient(cmd, marketplace.WithBaseURL(baseUrl), results.recording())
+		routes, err := newRoutes(cmd, client)
+		if err != nil {
+			color.Red("%s", err)
+			os.Exit(1)
+		}
+		l := &lifecycleConformance{
+			ctx:       ctx,
+			cmd:       cmd,
+			client:    client,
+			results:   results,
+			verbose:   cmd.Flag("verbose").Value.String() == "true",
+			keepGoing: cmd.Flag("continue-on-failure").Value.String() == "true",
+		}
+
+		unknownId := uuid.NewV4().String()
+		unknownEndpointId := uuid.NewV4().String()
+		quicknodeId := uuid.NewV4().String()
+		endpointId := uuid.NewV4().String()
+		plan := cmd.Flag("plan").Value.String()
+
+		l.expect("Update before provision is rejected", "PUT", routes.url("update", unknownId, unknownEndpointId), updateRequest(l.cmd, unknownId, unknownEndpointId, plan), rejectedStatuses)
+
+		provisioned := l.expect("Provision was successful", "POST", routes.url("provision", quicknodeId, endpointId), provisionRequest(l.cmd, quicknodeId, endpointId, plan), []int{http.StatusOK})
+		l.expect("Deactivate of an unknown endpoint-id is handled", "DELETE", routes.url("deactivate_endpoint", quicknodeId, unknownEndpointId), deactivateRequest(l.cmd, quicknodeId, unknownEndpointId), []int{http.StatusOK, http.StatusNotFound})
+		l.expect("Deactivate Endpoint was successful", "DELETE", routes.url("deactivate_endpoint", quicknodeId, endpointId), deactivateRequest(l.cmd, quicknodeId, endpointId), []int{http.StatusOK})
+		l.expect("Update of a deactivated endpoint is handled", "PUT", routes.url("update", quicknodeId, endpointId), updateRequest(l.cmd, quicknodeId, endpointId, plan), append([]int{http.StatusOK}, rejectedStatuses...))
+
+		l.expect("Deprovision of an unknown quicknode-id is handled", "DELETE", routes.url("deprovision", unknownId, ""), deprovisionRequest(l.cmd, unknownId), []int{http.StatusOK, http.StatusNotFound})
+		if provisioned {
+			l.expect("Deprovision was successful", "DELETE", routes.url("deprovision", quicknodeId, ""), deprovisionRequest(l.cmd, quicknodeId), []int{http.StatusOK})
+		}
+		if l.expect("Provision after deprovision was successful", "POST", routes.url("provision", quicknodeId, endpointId), provisionRequest(l.cmd, quicknodeId, endpointId, plan), []int{http.StatusOK}) {
+			// Clean up the account provisioned again above
+			l.expect("Deprovision after provisioning again was successful", "DELETE", routes.url("deprovision", quicknodeId, ""), deprovisionRequest(l.cmd, quicknodeId), []int{http.StatusOK})
+		}
+
+		if l.keepGoing {
+			results.printSummary()
+		}
+		results.finish()
+	},
+}
+
+// rejectedStatuses are the client errors an add-on can sensibly use to refuse a
+// call about an account or endpoint it can't act on.
+var rejectedStatuses = []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusGone, http.StatusUnprocessableEntity}
+
+func init() {
+	rootCmd.AddCommand(conformanceCmd)
+	conformanceCmd.AddCommand(conformanceLifecycleCmd)
+
+	conformanceLifecycleCmd.PersistentFlags().StringP("base-url", "u", "", "The base URL of the add-on's provisioning API")
+
+	// Note: basic auth defaults to username = Aladdin and password = open sesame
+	conformanceLifecycleCmd.PersistentFlags().String("basic-auth", "QWxhZGRpbjpvcGVuIHNlc2FtZQ==", "The basic auth credentials for the add-on. Defaults to username = Aladdin and password = open sesame")
+
+	conformanceLifecycleCmd.PersistentFlags().StringP("endpoint-url", "l", "https://long-late-firefly.quiknode.pro/4bb1e6b2dec8294938b6fdfdb7cf0cf70c4e97a2/", "The endpoint URL to provision the add-on for (optional - defaults to an ethereum mainnet endpoint")
+	conformanceLifecycleCmd.PersistentFlags().StringP("wss-url", "w", "wss://long-late-firefly.quiknode.pro/4bb1e6b2dec8294938b6fdfdb7cf0cf70c4e97a2/", "The WSS URL to provision the add-on for (optional - defaults to an ethereum mainnet endpoint")
+	conformanceLifecycleCmd.PersistentFlags().StringP("chain", "c", "ethereum", "The chain to provision the add-on for")
+	conformanceLifecycleCmd.PersistentFlags().StringP("network", "n", "mainnet", "The network to provision the add-on for")
+	conformanceLifecycleCmd.PersistentFlags().StringP("plan", "p", "discover", "The plan to provision the add-on for")
+	conformanceLifecycleCmd.PersistentFlags().StringP("add-on-id", "i", "33", "The ID of the add-on to provision")
+	conformanceLifecycleCmd.PersistentFlags().StringP("add-on-slug", "s", "myslug", "The slug of the add-on to provision")
+	addWhitelistFlags(conformanceLifecycleCmd)
+
+	addRouteFlags(conformanceLifecycleCmd)
+
+	conformanceLifecycleCmd.PersistentFlags().Bool("continue-on-failure", false, "Run every case even if an earlier one failed, then print a summary of all the results")
+}
+
+// lifecycleConformance holds the state shared by the cases of the lifecycle suite.
+type lifecycleConformance struct {
+	ctx       context.Context
+	cmd       *cobra.Command
+	client    *marketplace.Client
+	results   *results
+	verbose   bool
+	keepGoing bool
+}
+
+// expect sends payload to the provisioning route at url and checks the add-on
+// responded with one of the accepted statuses.
+func (l *lifecycleConformance) expect(name string, httpMethod string, url string, payload interface{}, accepted []int) bool {
+	if l.verbose {
+		payloadJson, _ := json.MarshalIndent(payload, "", "  ")
+		color.Blue("\n\n→ %s %s:\n", httpMethod, url)
+		fmt.Printf("%s\n", payloadJson)
+	}
+	return l.results.run(name, l.keepGoing, func() error {
+		res, err := l.client.Send(l.ctx, httpMethod, url, payload)
+		if err != nil {
+			return err
+		}
+		if l.verbose {
+			fmt.Printf("\n← %d %s\n%s\n\n", res.StatusCode, http.StatusText(res.StatusCode), res.Body)
+		}
+		for _, status := range accepted {
+			if res.StatusCode == status {
+				return nil
+			}
+		}
+		if res.StatusCode >= 500 {
+			return fmt.Errorf("the add-on crashed with %d %s instead of responding with %s:\n%s", res.StatusCode, http.StatusText(res.StatusCode), joinStatuses(accepted), res.Body)
+		}
+		return fmt.Errorf("expected %s, got %d %s:\n%s", joinStatuses(accepted), res.StatusCode, http.StatusText(res.StatusCode), res.Body)
+	})
+}
+
+// joinStatuses formats status codes as e.g. "200, 404 or 409".
+func joinStatuses(statuses []int) string {
+	var s []string
+	for _, status := range statuses {
+		s = append(s, fmt.Sprint(status))
+	}
+	if len(s) == 1 {
+		return s[0]
+	}
+	return strings.Join(s[:len(s)-1], ", ") + " or " + s[len(s)-1]
+}
@@ -24,14 +24,19 @@ var deactivateCmd = &cobra.Command{
 Learn more at https://www.quicknode.com/guides/quicknode-products/marketplace/how-provisioning-works-for-marketplace-partners/`,
 	Args: cobra.OnlyValidArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		header := color.New(color.FgWhite, color.BgBlue).SprintFunc()
-		fmt.Printf("%s\n\n", header("        DEACTIVATE        "))
+		printHeader("DEACTIVATE")
+		targetInstance(cmd)
 		verbose := cmd.Flag("verbose").Value.String() == "true"
 		url := cmd.Flag("url").Value.String()
 		if url == "" {
 			fmt.Print("Please provide a URL for the deactivate API via the --url flag\n")
 			os.Exit(1)
 		}
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		results := newResults(cmd, "deactivate")
+		client := newClient(cmd, results.recording())
+
 		request := marketplace.DeactivateRequest{
 			QuickNodeId:  cmd.Flag("quicknode-id").Value.String(),
 			EndpointId:   cmd.Flag("endpoint-id").Value.String(),
@@ -41,38 +46,36 @@ Learn more at https://www.quicknode.com/guides/quicknode-products/marketplace/ho
 			AddOnId:      cmd.Flag("add-on-id").Value.String(),
 			AddOnSlug:    cmd.Flag("add-on-slug").Value.String(),
 		}
+		payload := requestPayload(cmd, &request)
 
 		// Check that it is protected by basic auth
-		isProtectedByBasicAuth, err := marketplace.RequiresBasicAuth(url, "DELETE")
-		if err != nil {
-			color.Red("%s", err)
-			os.Exit(1)
-		}
-		if !isProtectedByBasicAuth {
-			color.Red("  ✘ The deactivate_endpoint API is not protected by basic auth.")
-			os.Exit(1)
-		}
+		results.run("Deactivate Endpoint API is protected by basic auth", false, func() error {
+			return checkBasicAuth(ctx, client, url, "DELETE", "deactivate_endpoint")
+		})
 
 		if verbose {
 			color.Blue("→ DELETE %s:\n", url)
 		}
-		requestJson, _ := json.MarshalIndent(request, "", "  ")
+		requestJson, _ := json.MarshalIndent(payload, "", "  ")
 		if verbose {
 			fmt.Printf("%s\n", requestJson)
 		}
 
-		response, err := marketplace.Deactivate(url, request, cmd.Flag("basic-auth").Value.String())
-		if err != nil {
-			color.Red("%s", err)
-			os.Exit(1)
-		}
+		results.run("Deactivate Endpoint was successful", false, func() error {
+			var response marketplace.DeactivateResponse
+			if err := client.Call(ctx, "DELETE", url, payload, &response); err != nil {
+				return err
+			}
 
-		if verbose {
-			fmt.Printf("\nDeactivate Endpoint was successful:\n")
-			fmt.Printf("  Status:     %s\n\n", response.Status)
-		}
+			if verbose {
+				fmt.Printf("\nDeactivate Endpoint was successful:\n")
+				fmt.Printf("  Status:     %s\n\n", response.Status)
+			}
+			recordStatus(cmd, request.QuickNodeId, request.EndpointId, instanceDeactivated, "")
+			return nil
+		})
 
-		color.Green("  ✓ Deactivate Endpoint was successful")
+		results.finish()
 	},
 }
 
@@ -90,4 +93,6 @@ func init() {
 	deactivateCmd.PersistentFlags().StringP("network", "n", "mainnet", "The network to provision the add-on for")
 	deactivateCmd.PersistentFlags().StringP("add-on-id", "i", "33", "The ID of the add-on to provision")
 	deactivateCmd.PersistentFlags().StringP("add-on-slug", "s", "myslug", "The slug of the add-on to provision")
+	addPayloadFlags(deactivateCmd)
+	addInstanceFlag(deactivateCmd)
 }
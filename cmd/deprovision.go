@@ -23,52 +23,54 @@ var deprovisionCmd = &cobra.Command{
 Learn more at https://www.quicknode.com/guides/quicknode-products/marketplace/how-provisioning-works-for-marketplace-partners/`,
 	Args: cobra.OnlyValidArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		header := color.New(color.FgWhite, color.BgBlue).SprintFunc()
-		fmt.Printf("%s\n\n", header("        DEPROVISION        "))
+		printHeader("DEPROVISION")
+		targetInstance(cmd)
 		verbose := cmd.Flag("verbose").Value.String() == "true"
 		url := cmd.Flag("url").Value.String()
 		if url == "" {
 			fmt.Print("Please provide a URL for the deprovision API via the --url flag\n")
 			os.Exit(1)
 		}
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		results := newResults(cmd, "deprovision")
+		client := newClient(cmd, results.recording())
 
 		request := marketplace.DeprovisionRequest{
 			QuickNodeId: cmd.Flag("quicknode-id").Value.String(),
 			AddOnId:     cmd.Flag("add-on-id").Value.String(),
 			AddOnSlug:   cmd.Flag("add-on-slug").Value.String(),
 		}
+		payload := requestPayload(cmd, &request)
 
 		// Check that it is protected by basic auth
-		isProtectedByBasicAuth, err := marketplace.RequiresBasicAuth(url, "DELETE")
-		if err != nil {
-			color.Red("%s", err)
-			os.Exit(1)
-		}
-		if !isProtectedByBasicAuth {
-			color.Red("  ✘ The deprovision API is not protected by basic auth.")
-			os.Exit(1)
-		}
+		results.run("Deprovision API is protected by basic auth", false, func() error {
+			return checkBasicAuth(ctx, client, url, "DELETE", "deprovision")
+		})
 
 		if verbose {
 			color.Blue("→ DELETE %s:\n", url)
 		}
-		requestJson, _ := json.MarshalIndent(request, "", "  ")
+		requestJson, _ := json.MarshalIndent(payload, "", "  ")
 		if verbose {
 			fmt.Printf("%s\n", requestJson)
 		}
 
-		response, err := marketplace.Deprovision(url, request, cmd.Flag("basic-auth").Value.String())
-		if err != nil {
-			color.Red("%s", err)
-			os.Exit(1)
-		}
+		results.run("Deprovision was successful", false, func() error {
+			var response marketplace.DeprovisionResponse
+			if err := client.Call(ctx, "DELETE", url, payload, &response); err != nil {
+				return err
+			}
 
-		if verbose {
-			fmt.Printf("\nDeprovision was successful:\n")
-			fmt.Printf("\tStatus: \t\t%s\n\n", response.Status)
-		}
+			if verbose {
+				fmt.Printf("\nDeprovision was successful:\n")
+				fmt.Printf("\tStatus: \t\t%s\n\n", response.Status)
+			}
+			recordStatus(cmd, request.QuickNodeId, "", instanceDeprovisioned, "")
+			return nil
+		})
 
-		color.Green("  ✓ Deprovision was successful")
+		results.finish()
 	},
 }
 
@@ -83,4 +85,6 @@ func init() {
 	deprovisionCmd.PersistentFlags().StringP("quicknode-id", "q", uuid.NewV4().String(), "The QuickNode ID to provision the add-on for (optional)")
 	deprovisionCmd.PersistentFlags().StringP("add-on-id", "i", "33", "The ID of the add-on to provision")
 	deprovisionCmd.PersistentFlags().StringP("add-on-slug", "s", "myslug", "The slug of the add-on to provision")
+	addPayloadFlags(deprovisionCmd)
+	addInstanceFlag(deprovisionCmd)
 }
@@ -0,0 +1,281 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+	"github.com/quiknode-labs/qn-marketplace-cli/marketplace"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/cobra"
+)
+
+// fuzzCmd represents the fuzz command
+var fuzzCmd = &cobra.Command{
+	Use:   "fuzz",
+	Short: "Allows you to test how your add-on copes with malformed input",
+}
+
+// fuzzProvisioningCmd represents the fuzz provisioning command
+var fuzzProvisioningCmd = &cobra.Command{
+	Use:   "provisioning",
+	Short: "Sends mutated provisioning payloads and checks your add-on doesn't crash on them",
+	Long: `Use this command to make sure your provisioning API survives malformed payloads: missing
+fields, fields of the wrong type, empty, huge and unicode strings, null arrays, invalid http-url
+and wss-url, negative deactivate-at, and payloads that aren't JSON objects at all.
+
+Every mutation is sent to each provisioning route, and fails if the add-on:
+  - responds with a 5xx
+  - doesn't respond before --timeout
+  - accepts clearly invalid input with a 2xx (a missing or mistyped quicknode-id, endpoint-id
+    or plan, an invalid URL, a negative deactivate-at, or a payload that isn't an object)
+
+It uses the same base URL and routes as pudd, with fresh QuickNode and endpoint IDs for every
+run, and deprovisions the accounts it provisioned at the end.
+`,
+	Args: cobra.OnlyValidArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		printHeader("FUZZ: PROVISIONING")
+		verbose := cmd.Flag("verbose").Value.String() == "true"
+		baseUrl := cmd.Flag("base-url").Value.String()
+		if baseUrl == "" {
+			fmt.Print("Please provide a base URL for the provisioning API via the --base-url flag\n")
+			os.Exit(1)
+		}
+		actions, _ := cmd.Flags().GetStringSlice("actions")
+		for _, action := range actions {
+			if fuzzMethods[action] == "" {
+				color.Red("Unknown action %q in --actions, expected provision, update, deactivate_endpoint or deprovision", action)
+				os.Exit(1)
+			}
+		}
+		hugeSize, _ := cmd.Flags().GetInt("huge-size")
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		results := newResults(cmd, "fuzz-provisioning")
+		client := newClient(cmd, marketplace.WithBaseURL(baseUrl), results.recording())
+		routes, err := newRoutes(cmd, client)
+		if err != nil {
+			color.Red("%s", err)
+			os.Exit(1)
+		}
+		cleanup := &cleaner{cmd: cmd, client: client, results: results, routes: routes, verbose: verbose}
+		results.onFinish(cleanup.clean)
+
+		quicknodeId := uuid.NewV4().String()
+		endpointId := uuid.NewV4().String()
+		plan := cmd.Flag("plan").Value.String()
+		requests := map[string]interface{}{
+			"provision":           provisionRequest(cmd, quicknodeId, endpointId, plan),
+			"update":              updateRequest(cmd, quicknodeId, endpointId, plan),
+			"deactivate_endpoint": deactivateRequest(cmd, quicknodeId, endpointId),
+			"deprovision":         deprovisionRequest(cmd, quicknodeId),
+		}
+
+		for _, action := range actions {
+			url := routes.url(action, quicknodeId, endpointId)
+			for _, c := range fuzzCases(requests[action], hugeSize) {
+				c := c
+				if action == "provision" {
+					cleanup.track(url, provisionedBy(requests[action], c.Payload))
+				}
+				if verbose {
+					payloadJson, _ := json.Marshal(c.Payload)
+					color.Blue("\n→ %s %s (%s):\n", fuzzMethods[action], url, c.Name)
+					fmt.Printf("%s\n", truncate(string(payloadJson), 500))
+				}
+				results.run(fmt.Sprintf("%s: %s", action, c.Name), true, func() error {
+					return checkFuzzResponse(ctx, client, fuzzMethods[action], url, c)
+				})
+			}
+		}
+
+		cleanup.clean()
+		results.printSummary()
+		results.finish()
+	},
+}
+
+// fuzzMethods are the HTTP methods of the provisioning actions.
+var fuzzMethods = map[string]string{
+	"provision":           "POST",
+	"update":              "PUT",
+	"deactivate_endpoint": "DELETE",
+	"deprovision":         "DELETE",
+}
+
+// fuzzRequiredFields are the fields an add-on can't act without.
+var fuzzRequiredFields = map[string]bool{"quicknode-id": true, "endpoint-id": true, "plan": true}
+
+// fuzzCase is a mutated payload. Invalid ones must not be accepted.
+type fuzzCase struct {
+	Name    string
+	Payload interface{}
+	Invalid bool
+}
+
+// fuzzCases returns the mutations of a request, field by field, then of the
+// payload as a whole.
+func fuzzCases(request interface{}, hugeSize int) []fuzzCase {
+	encoded, _ := json.Marshal(request)
+	var base map[string]interface{}
+	json.Unmarshal(encoded, &base)
+
+	var fields []string
+	for field := range base {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	huge := strings.Repeat("A", hugeSize)
+	unicode := "💥 Ünïcødé 中文 العربية \u202e\u0000 \ufeff"
+	var cases []fuzzCase
+	mutate := func(name string, field string, value interface{}, invalid bool) {
+		payload := map[string]interface{}{}
+		for k, v := range base {
+			payload[k] = v
+		}
+		payload[field] = value
+		cases = append(cases, fuzzCase{Name: fmt.Sprintf("%s %s", field, name), Payload: payload, Invalid: invalid})
+	}
+
+	for _, field := range fields {
+		required := fuzzRequiredFields[field]
+		payload := map[string]interface{}{}
+		for k, v := range base {
+			if k != field {
+				payload[k] = v
+			}
+		}
+		cases = append(cases, fuzzCase{Name: "missing " + field, Payload: payload, Invalid: required})
+
+		switch base[field].(type) {
+		case string:
+			mutate("as null", field, nil, required)
+			mutate("as a number", field, 12345, required)
+			mutate("as an object", field, map[string]interface{}{"value": base[field]}, required)
+			mutate("as an array", field, []interface{}{base[field]}, required)
+			mutate("empty", field, "", required)
+			mutate(fmt.Sprintf("of %d bytes", hugeSize), field, huge, false)
+			mutate("with unicode", field, unicode, false)
+			if field == "http-url" || field == "wss-url" {
+				mutate("not a URL", field, "not a url", true)
+				mutate("with a javascript: URL", field, "javascript:alert(1)", true)
+				mutate("with a relative URL", field, "/relative/path", true)
+				mutate("with a URL of the wrong scheme", field, strings.Replace(strings.Replace(base[field].(string), "wss://", "ftp://", 1), "https://", "ftp://", 1), true)
+			}
+		case []interface{}:
+			mutate("as null", field, nil, false)
+			mutate("as a string", field, "https://quicknode.com", false)
+			mutate("with items of the wrong type", field, []interface{}{1, true, map[string]interface{}{}, nil}, false)
+			items := make([]interface{}, 10000)
+			for i := range items {
+				items[i] = fmt.Sprintf("https://%d.example.com", i)
+			}
+			mutate("with 10000 items", field, items, false)
+			mutate("with a huge item", field, []interface{}{huge}, false)
+		case float64:
+			invalid := field == "deactivate-at"
+			mutate("negative", field, -1, invalid)
+			mutate("as a string", field, fmt.Sprint(base[field]), invalid)
+			mutate("as null", field, nil, invalid)
+			mutate("as a float", field, 1.5, false)
+			mutate("too large", field, 1e300, false)
+		}
+	}
+
+	withUnknown := map[string]interface{}{"unknown-field": unicode}
+	for k, v := range base {
+		withUnknown[k] = v
+	}
+	return append(cases,
+		fuzzCase{Name: "unknown field", Payload: withUnknown},
+		fuzzCase{Name: "empty object", Payload: map[string]interface{}{}, Invalid: true},
+		fuzzCase{Name: "null payload", Payload: nil, Invalid: true},
+		fuzzCase{Name: "array payload", Payload: []interface{}{base}, Invalid: true},
+		fuzzCase{Name: "string payload", Payload: string(encoded), Invalid: true},
+	)
+}
+
+// checkFuzzResponse sends a case and returns an error if the add-on crashed,
+// hung or accepted invalid input.
+func checkFuzzResponse(ctx context.Context, client *marketplace.Client, httpMethod string, url string, c fuzzCase) error {
+	res, err := client.Send(ctx, httpMethod, url, c.Payload)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("the add-on did not respond in time: %w", err)
+	}
+	if err != nil {
+		return err
+	}
+	body := truncate(string(res.Body), 500)
+	if res.StatusCode >= 500 {
+		return fmt.Errorf("the add-on crashed with %d %s:\n%s", res.StatusCode, http.StatusText(res.StatusCode), body)
+	}
+	if c.Invalid && res.StatusCode >= 200 && res.StatusCode < 300 {
+		return fmt.Errorf("the add-on accepted clearly invalid input with %d %s instead of a 4xx:\n%s", res.StatusCode, http.StatusText(res.StatusCode), body)
+	}
+	return nil
+}
+
+// provisionedBy returns the provision request a mutated payload may have
+// provisioned, to clean it up afterwards.
+func provisionedBy(request interface{}, payload interface{}) marketplace.ProvisionRequest {
+	provisioned := request.(marketplace.ProvisionRequest)
+	if fields, ok := payload.(map[string]interface{}); ok {
+		if id, ok := fields["quicknode-id"].(string); ok && id != "" {
+			provisioned.QuickNodeId = id
+		}
+		if id, ok := fields["endpoint-id"].(string); ok && id != "" {
+			provisioned.EndpointId = id
+		}
+	}
+	return provisioned
+}
+
+// truncate shortens s to at most n bytes, e.g. to print huge payloads.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	// Don't cut a multi-byte character in half
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return fmt.Sprintf("%s... (%d more bytes)", s[:n], len(s)-n)
+}
+
+func init() {
+	rootCmd.AddCommand(fuzzCmd)
+	fuzzCmd.AddCommand(fuzzProvisioningCmd)
+
+	fuzzProvisioningCmd.PersistentFlags().StringP("base-url", "u", "", "The base URL of the add-on's provisioning API")
+
+	// Note: basic auth defaults to username = Aladdin and password = open sesame
+	fuzzProvisioningCmd.PersistentFlags().String("basic-auth", "QWxhZGRpbjpvcGVuIHNlc2FtZQ==", "The basic auth credentials for the add-on. Defaults to username = Aladdin and password = open sesame")
+
+	fuzzProvisioningCmd.PersistentFlags().StringP("endpoint-url", "l", "https://long-late-firefly.quiknode.pro/4bb1e6b2dec8294938b6fdfdb7cf0cf70c4e97a2/", "The endpoint URL to provision the add-on for (optional - defaults to an ethereum mainnet endpoint")
+	fuzzProvisioningCmd.PersistentFlags().StringP("wss-url", "w", "wss://long-late-firefly.quiknode.pro/4bb1e6b2dec8294938b6fdfdb7cf0cf70c4e97a2/", "The WSS URL to provision the add-on for (optional - defaults to an ethereum mainnet endpoint")
+	fuzzProvisioningCmd.PersistentFlags().StringP("chain", "c", "ethereum", "The chain to provision the add-on for")
+	fuzzProvisioningCmd.PersistentFlags().StringP("network", "n", "mainnet", "The network to provision the add-on for")
+	fuzzProvisioningCmd.PersistentFlags().StringP("plan", "p", "discover", "The plan to provision the add-on for")
+	fuzzProvisioningCmd.PersistentFlags().StringP("add-on-id", "i", "33", "The ID of the add-on to provision")
+	fuzzProvisioningCmd.PersistentFlags().StringP("add-on-slug", "s", "myslug", "The slug of the add-on to provision")
+	addWhitelistFlags(fuzzProvisioningCmd)
+	addRouteFlags(fuzzProvisioningCmd)
+
+	fuzzProvisioningCmd.PersistentFlags().StringSlice("actions", []string{"provision", "update", "deactivate_endpoint", "deprovision"}, "The provisioning actions to fuzz")
+	fuzzProvisioningCmd.PersistentFlags().Int("huge-size", 256*1024, "The size in bytes of the huge strings sent")
+}
@@ -0,0 +1,28 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import "testing"
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want string
+	}{
+		{"short", "abc", 3, "abc"},
+		{"ascii", "abcdef", 3, "abc... (3 more bytes)"},
+		{"rune boundary", "aéb", 3, "aé... (1 more bytes)"},
+		{"inside a rune", "aéb", 2, "a... (3 more bytes)"},
+		{"inside a 4-byte rune", "a😀", 4, "a... (4 more bytes)"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := truncate(test.s, test.n); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -1,15 +1,12 @@
 /*
 Copyright © 2023 NAME HERE <EMAIL ADDRESS>
-
 */
 package cmd
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/fatih/color"
-	"github.com/quiknode-labs/qn-marketplace-cli/marketplace"
 	"github.com/spf13/cobra"
 )
 
@@ -19,26 +16,31 @@ var healthcheckCmd = &cobra.Command{
 	Short: "Allows you to test your add-on's healthcheck implementation",
 	Args:  cobra.OnlyValidArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		header := color.New(color.FgWhite, color.BgBlue).SprintFunc()
-		fmt.Printf("%s\n\n", header("        Healthcheck        "))
+		printHeader("Healthcheck")
 		verbose := cmd.Flag("verbose").Value.String() == "true"
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		results := newResults(cmd, "healthcheck")
+		client := newClient(cmd, results.recording())
 		url := cmd.Flag("url").Value.String()
 
 		if verbose {
 			color.Blue("→ GET %s:\n", url)
 		}
-		statusCode, responseBody, err := marketplace.Healthcheck(url)
-		if err != nil {
-			color.Red("%s", err)
-			os.Exit(1)
-		}
-
-		if verbose {
-			fmt.Println("\nResponse body:")
-			fmt.Printf("%s\n\n", responseBody)
-		}
-
-		color.Green("  ✓ Healthcheck was successful with HTTP response code: %d", statusCode)
+		results.run("Healthcheck was successful", false, func() error {
+			statusCode, responseBody, err := client.Healthcheck(ctx, url)
+			if err != nil {
+				return err
+			}
+
+			if verbose {
+				fmt.Printf("\nStatus Code: %d\nResponse body:\n", statusCode)
+				fmt.Printf("%s\n\n", responseBody)
+			}
+			return nil
+		})
+
+		results.finish()
 	},
 }
 
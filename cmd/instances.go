@@ -0,0 +1,122 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// instancesCmd represents the instances command
+var instancesCmd = &cobra.Command{
+	Use:   "instances",
+	Short: "Lists the add-on instances provisioned by the CLI",
+	Long: `The provision, rpc, rest and sso commands record every instance they provision in a
+local state file (see --state-file), and update, deactivate and deprovision keep its
+status up to date. Use --instance on those commands to target a recorded instance by
+name, quicknode-id or endpoint-id, or "last" for the last provisioned one:
+
+  qn-marketplace-cli provision --url http://localhost:3000/provision --instance-name staging
+  qn-marketplace-cli update --url http://localhost:3000/update --instance staging --plan pro
+  qn-marketplace-cli deprovision --url http://localhost:3000/deprovision --instance last`,
+}
+
+var instancesListCmd = &cobra.Command{
+	Use:         "list",
+	Short:       "Lists the recorded instances",
+	Args:        cobra.NoArgs,
+	Annotations: map[string]string{recordsAnnotation: ""},
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := openInstanceStore(cmd)
+		if err != nil {
+			color.Red("%s", err)
+			os.Exit(1)
+		}
+		all := cmd.Flag("all").Value.String() == "true"
+		instances := []*instance{}
+		for _, inst := range store.Instances {
+			if all || inst.Status != instanceDeprovisioned {
+				instances = append(instances, inst)
+			}
+		}
+
+		if outputFormat != outputText {
+			if err := writeRecord(instances); err != nil {
+				color.Red("Could not write the instances: %s", err)
+				os.Exit(1)
+			}
+			return
+		}
+		if len(instances) == 0 {
+			fmt.Printf("No instances recorded in %s\n", store.path)
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintf(w, "NAME\tQUICKNODE ID\tENDPOINT ID\tCHAIN\tNETWORK\tPLAN\tSTATUS\tPROVISIONED\n")
+		for _, inst := range instances {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", inst.Name, inst.QuickNodeId, inst.EndpointId, inst.Chain, inst.Network, inst.Plan, inst.Status, inst.ProvisionedAt.Local().Format(time.RFC3339))
+		}
+		w.Flush()
+	},
+}
+
+var instancesShowCmd = &cobra.Command{
+	Use:         "show [name|quicknode-id|endpoint-id|last]",
+	Short:       "Shows a recorded instance, the last provisioned one by default",
+	Args:        cobra.MaximumNArgs(1),
+	Annotations: map[string]string{recordsAnnotation: ""},
+	Run: func(cmd *cobra.Command, args []string) {
+		ref := lastInstance
+		if len(args) > 0 {
+			ref = args[0]
+		}
+		store, err := openInstanceStore(cmd)
+		if err != nil {
+			color.Red("%s", err)
+			os.Exit(1)
+		}
+		inst, err := store.find(ref)
+		if err != nil {
+			color.Red("%s", err)
+			os.Exit(1)
+		}
+
+		if outputFormat != outputText {
+			if err := writeRecord(inst); err != nil {
+				color.Red("Could not write the instance: %s", err)
+				os.Exit(1)
+			}
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintf(w, "Name:\t%s\n", inst.Name)
+		fmt.Fprintf(w, "QuickNode ID:\t%s\n", inst.QuickNodeId)
+		fmt.Fprintf(w, "Endpoint ID:\t%s\n", inst.EndpointId)
+		fmt.Fprintf(w, "Chain:\t%s\n", inst.Chain)
+		fmt.Fprintf(w, "Network:\t%s\n", inst.Network)
+		fmt.Fprintf(w, "Plan:\t%s\n", inst.Plan)
+		fmt.Fprintf(w, "Add-on ID:\t%s\n", inst.AddOnId)
+		fmt.Fprintf(w, "Add-on slug:\t%s\n", inst.AddOnSlug)
+		fmt.Fprintf(w, "Dashboard URL:\t%s\n", inst.DashboardURL)
+		fmt.Fprintf(w, "Access URL:\t%s\n", inst.AccessURL)
+		fmt.Fprintf(w, "Provision URL:\t%s\n", inst.ProvisionURL)
+		fmt.Fprintf(w, "Status:\t%s\n", inst.Status)
+		fmt.Fprintf(w, "Provisioned at:\t%s\n", inst.ProvisionedAt.Local().Format(time.RFC3339))
+		fmt.Fprintf(w, "Updated at:\t%s\n", inst.UpdatedAt.Local().Format(time.RFC3339))
+		w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(instancesCmd)
+	instancesCmd.AddCommand(instancesListCmd)
+	instancesCmd.AddCommand(instancesShowCmd)
+
+	instancesListCmd.Flags().Bool("all", false, "Also list the deprovisioned instances")
+}
@@ -0,0 +1,395 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/quiknode-labs/qn-marketplace-cli/marketplace"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/cobra"
+)
+
+// jwtCmd represents the jwt command
+var jwtCmd = &cobra.Command{
+	Use:   "jwt",
+	Short: "Allows you to mint, decode and verify SSO tokens",
+}
+
+// jwtMintCmd represents the jwt mint command
+var jwtMintCmd = &cobra.Command{
+	Use:         "mint",
+	Short:       "Prints an SSO token like the one the marketplace sends to your dashboard",
+	Annotations: map[string]string{recordsAnnotation: ""},
+	Long: `Use this command to get an SSO token signed the same way as the sso command's, e.g. to
+log into your dashboard at dashboard-url?jwt=<token> or to debug how it handles tokens.
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if cmd.Flag("email").Value.String() == "" {
+			color.Red("Please provide the email of the user via the --email flag")
+			os.Exit(1)
+		}
+		algorithm := strings.ToUpper(cmd.Flag("jwt-algorithm").Value.String())
+		if algorithm != "RS256" && cmd.Flag("jwt-secret").Value.String() == "" {
+			color.Red("Please provide the JWT secret to sign %s tokens with via the --jwt-secret flag", algorithm)
+			os.Exit(1)
+		}
+		user := marketplace.User{
+			QuicknodeID:      cmd.Flag("quicknode-id").Value.String(),
+			Name:             cmd.Flag("name").Value.String(),
+			Email:            cmd.Flag("email").Value.String(),
+			OrganizationName: cmd.Flag("org").Value.String(),
+		}
+		token, err := marketplace.GetJWT(cmd.Flag("jwt-secret").Value.String(), user, jwtOptions(cmd)...)
+		if err != nil {
+			color.Red("Could not generate JWT: %s", err)
+			os.Exit(1)
+		}
+
+		if outputFormat != outputText {
+			if err := writeRecord(map[string]string{"token": token}); err != nil {
+				color.Red("Could not write the token: %s", err)
+				os.Exit(1)
+			}
+		} else {
+			fmt.Println(token)
+		}
+		if cmd.Flag("copy").Value.String() == "true" {
+			if err := copyToClipboard(token); err != nil {
+				color.Red("Could not copy the token to the clipboard: %s", err)
+				os.Exit(1)
+			}
+			if outputFormat == outputText {
+				color.Green("  ✓ Copied the token to the clipboard")
+			}
+		}
+	},
+}
+
+// jwtDecodeCmd represents the jwt decode command
+var jwtDecodeCmd = &cobra.Command{
+	Use:         "decode [token|-]",
+	Short:       "Prints the header and claims of a token, without verifying it",
+	Annotations: map[string]string{recordsAnnotation: ""},
+	Long: `Use this command to see what a token carries and whether it has expired. The token is read
+from stdin when it is - or missing. Its signature isn't checked, use jwt verify for that.
+`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		raw, err := readToken(args)
+		if err != nil {
+			color.Red("%s", err)
+			os.Exit(1)
+		}
+		claims := jwt.MapClaims{}
+		token, _, err := jwt.NewParser().ParseUnverified(raw, claims)
+		if err != nil {
+			color.Red("Could not decode the token: %s", err)
+			os.Exit(1)
+		}
+
+		if outputFormat != outputText {
+			if err := writeRecord(decodedToken{Header: token.Header, Claims: claims, Expired: tokenExpired(claims)}); err != nil {
+				color.Red("Could not write the token: %s", err)
+				os.Exit(1)
+			}
+			return
+		}
+		header, _ := json.MarshalIndent(token.Header, "", "  ")
+		payload, _ := json.MarshalIndent(claims, "", "  ")
+		color.Blue("Header:")
+		fmt.Printf("%s\n", header)
+		color.Blue("Claims:")
+		fmt.Printf("%s\n\n", payload)
+		printTokenTime("Issued at", claims, "iat")
+		printTokenTime("Not before", claims, "nbf")
+		printTokenTime("Expires at", claims, "exp")
+		switch expired := tokenExpired(claims); {
+		case expired == nil:
+			color.Yellow("  ! The token has no exp claim")
+		case *expired:
+			color.Red("  ✘ The token has expired")
+		default:
+			color.Green("  ✓ The token has not expired")
+		}
+	},
+}
+
+// jwtVerifyCmd represents the jwt verify command
+var jwtVerifyCmd = &cobra.Command{
+	Use:         "verify [token|-]",
+	Short:       "Checks a token the way your dashboard should",
+	Annotations: map[string]string{recordsAnnotation: ""},
+	Long: `Use this command to find out why your dashboard rejects a token, or to make sure it would.
+The token is read from stdin when it is - or missing. It is valid if:
+  - it is signed with --jwt-algorithm and --jwt-secret (or the RSA key for RS256)
+  - it has iat and exp claims no further apart than --jwt-ttl, and is valid now
+  - it has the name, email, organization_name and quicknode_id claims (or the names set
+    with --jwt-claim-name), email and quicknode_id aren't empty, and it carries the
+    claims of --jwt-claims with the same values
+`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		raw, err := readToken(args)
+		if err != nil {
+			color.Red("%s", err)
+			os.Exit(1)
+		}
+		claims, err := marketplace.VerifyJWT(raw, cmd.Flag("jwt-secret").Value.String(), jwtOptions(cmd)...)
+
+		if outputFormat != outputText {
+			record := verifiedToken{Valid: err == nil, Claims: claims}
+			if err != nil {
+				record.Error = err.Error()
+			}
+			if err := writeRecord(record); err != nil {
+				color.Red("Could not write the result: %s", err)
+				os.Exit(1)
+			}
+		} else if err != nil {
+			color.Red("  ✘ The token is invalid: %s", err)
+		} else {
+			color.Green("  ✓ The token is valid")
+		}
+		if err != nil {
+			os.Exit(1)
+		}
+	},
+}
+
+// decodedToken is the machine-readable output of jwt decode. Expired is null
+// when the token has no exp claim.
+type decodedToken struct {
+	Header  map[string]interface{} `json:"header"`
+	Claims  jwt.MapClaims          `json:"claims"`
+	Expired *bool                  `json:"expired"`
+}
+
+// verifiedToken is the machine-readable output of jwt verify.
+type verifiedToken struct {
+	Valid  bool          `json:"valid"`
+	Error  string        `json:"error,omitempty"`
+	Claims jwt.MapClaims `json:"claims"`
+}
+
+// readToken returns the token passed as argument, or read from stdin.
+func readToken(args []string) (string, error) {
+	if len(args) > 0 && args[0] != "-" {
+		return strings.TrimSpace(args[0]), nil
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("could not read the token from stdin: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("please provide a token as argument or on stdin")
+	}
+	return token, nil
+}
+
+// tokenExpired reports whether a token's exp is past, nil if it has none.
+func tokenExpired(claims jwt.MapClaims) *bool {
+	expiresAt, err := claims.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		return nil
+	}
+	expired := !time.Now().Before(expiresAt.Time)
+	return &expired
+}
+
+// printTokenTime prints a time claim of a token and how far from now it is.
+func printTokenTime(label string, claims jwt.MapClaims, name string) {
+	if _, ok := claims[name]; !ok {
+		return
+	}
+	var date *jwt.NumericDate
+	var err error
+	switch name {
+	case "iat":
+		date, err = claims.GetIssuedAt()
+	case "nbf":
+		date, err = claims.GetNotBefore()
+	default:
+		date, err = claims.GetExpirationTime()
+	}
+	if err != nil || date == nil {
+		fmt.Printf("%-12s invalid %s claim: %v\n", label+":", name, claims[name])
+		return
+	}
+	since := time.Since(date.Time).Round(time.Second)
+	relative := fmt.Sprintf("%s ago", since)
+	if since < 0 {
+		relative = fmt.Sprintf("in %s", -since)
+	}
+	fmt.Printf("%-12s %s (%s)\n", label+":", date.Local().Format(time.RFC3339), relative)
+}
+
+// copyToClipboard copies text to the system clipboard with the platform's tool.
+func copyToClipboard(text string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("pbcopy")
+	case "windows":
+		cmd = exec.Command("clip")
+	case "linux":
+		for _, tool := range [][]string{{"wl-copy"}, {"xclip", "-selection", "clipboard"}, {"xsel", "--clipboard", "--input"}} {
+			if _, err := exec.LookPath(tool[0]); err == nil {
+				cmd = exec.Command(tool[0], tool[1:]...)
+				break
+			}
+		}
+		if cmd == nil {
+			return fmt.Errorf("please install wl-copy, xclip or xsel")
+		}
+	default:
+		return fmt.Errorf("unsupported platform")
+	}
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
+
+// addJWTFlags adds the flags configuring the SSO tokens a command signs.
+func addJWTFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("jwt-algorithm", "HS256", fmt.Sprintf("The algorithm the JWT is signed with (%s)", strings.Join(marketplace.JWTAlgorithms, ", ")))
+	cmd.PersistentFlags().String("jwt-private-key", "", "A PEM file with the RSA private key RS256 tokens are signed with, instead of --jwt-secret")
+	cmd.PersistentFlags().Duration("jwt-ttl", marketplace.DefaultJWTTTL, "How long the JWT is valid for")
+	cmd.PersistentFlags().String("jwt-claims", "", "A JSON file with extra claims to add to the JWT, e.g. iss or aud")
+	cmd.PersistentFlags().StringArray("jwt-claim-name", []string{}, fmt.Sprintf("Rename a claim of the JWT, as claim=name with claim one of %s (can be repeated, an empty name leaves the claim out)", strings.Join(marketplace.UserClaims, ", ")))
+}
+
+// jwtOptions returns the options the command's JWT flags set, exiting if
+// they are invalid.
+func jwtOptions(cmd *cobra.Command) []marketplace.JWTOption {
+	options, err := parseJWTOptions(cmd)
+	if err != nil {
+		color.Red("%s", err)
+		os.Exit(1)
+	}
+	return options
+}
+
+func parseJWTOptions(cmd *cobra.Command) ([]marketplace.JWTOption, error) {
+	ttl, _ := cmd.Flags().GetDuration("jwt-ttl")
+	claimNames, _ := cmd.Flags().GetStringArray("jwt-claim-name")
+	settings := jwtSettings{
+		Algorithm:  cmd.Flag("jwt-algorithm").Value.String(),
+		PrivateKey: cmd.Flag("jwt-private-key").Value.String(),
+		TTL:        ttl,
+		Claims:     cmd.Flag("jwt-claims").Value.String(),
+		ClaimNames: claimNames,
+	}
+	// Only commands verifying tokens have a --jwt-public-key flag
+	if flag := cmd.Flags().Lookup("jwt-public-key"); flag != nil {
+		settings.Verifying = true
+		settings.PublicKey = flag.Value.String()
+	}
+	return settings.options()
+}
+
+// jwtSettings configure the SSO tokens signed or verified by a command, from
+// its JWT flags, or signed by a scenario step.
+type jwtSettings struct {
+	Algorithm string
+	// PrivateKey, PublicKey and Claims are the paths of PEM and JSON files
+	PrivateKey string
+	PublicKey  string
+	TTL        time.Duration
+	Claims     string
+	// ClaimNames rename claims, as claim=name
+	ClaimNames []string
+	Verifying  bool
+}
+
+// options returns the options signing or verifying tokens with the
+// settings, reading the files they refer to.
+func (s jwtSettings) options() ([]marketplace.JWTOption, error) {
+	algorithm := strings.ToUpper(s.Algorithm)
+	if !contains(marketplace.JWTAlgorithms, algorithm) {
+		return nil, fmt.Errorf("invalid jwt-algorithm %q, expected one of %s", algorithm, strings.Join(marketplace.JWTAlgorithms, ", "))
+	}
+	switch {
+	case algorithm == "RS256" && s.Verifying && s.PrivateKey == "" && s.PublicKey == "":
+		return nil, fmt.Errorf("please provide the RSA key to verify RS256 tokens with via jwt-public-key or jwt-private-key")
+	case algorithm == "RS256" && !s.Verifying && s.PrivateKey == "":
+		return nil, fmt.Errorf("please provide the RSA private key to sign RS256 tokens with via jwt-private-key")
+	}
+	options := []marketplace.JWTOption{marketplace.WithAlgorithm(algorithm), marketplace.WithTTL(s.TTL)}
+
+	if s.PublicKey != "" {
+		data, err := os.ReadFile(s.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		key, err := jwt.ParseRSAPublicKeyFromPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwt-public-key %s: %w", s.PublicKey, err)
+		}
+		options = append(options, marketplace.WithPublicKey(key))
+	}
+
+	if s.PrivateKey != "" {
+		data, err := os.ReadFile(s.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwt-private-key %s: %w", s.PrivateKey, err)
+		}
+		options = append(options, marketplace.WithPrivateKey(key))
+	}
+
+	if s.Claims != "" {
+		data, err := os.ReadFile(s.Claims)
+		if err != nil {
+			return nil, err
+		}
+		var claims map[string]interface{}
+		if err := json.Unmarshal(data, &claims); err != nil {
+			return nil, fmt.Errorf("invalid jwt-claims %s, expected a JSON object: %w", s.Claims, err)
+		}
+		options = append(options, marketplace.WithClaims(claims))
+	}
+
+	names := map[string]string{}
+	for _, value := range nonEmpty(s.ClaimNames) {
+		claim, name, ok := strings.Cut(value, "=")
+		if !ok || !contains(marketplace.UserClaims, claim) {
+			return nil, fmt.Errorf("invalid jwt-claim-name %q, expected claim=name with claim one of %s", value, strings.Join(marketplace.UserClaims, ", "))
+		}
+		names[claim] = name
+	}
+	return append(options, marketplace.WithClaimNames(names)), nil
+}
+
+func init() {
+	rootCmd.AddCommand(jwtCmd)
+	jwtCmd.AddCommand(jwtMintCmd)
+	jwtCmd.AddCommand(jwtDecodeCmd)
+	jwtCmd.AddCommand(jwtVerifyCmd)
+
+	jwtMintCmd.PersistentFlags().StringP("jwt-secret", "j", "", "The JWT secret for the add-on")
+	jwtMintCmd.PersistentFlags().StringP("quicknode-id", "q", uuid.NewV4().String(), "The QuickNode ID of the user (optional)")
+	jwtMintCmd.PersistentFlags().String("name", "", "The name of the user")
+	jwtMintCmd.PersistentFlags().String("email", "", "The email of the user (required)")
+	jwtMintCmd.PersistentFlags().String("org", "", "The organization name of the user")
+	jwtMintCmd.PersistentFlags().Bool("copy", false, "Copy the token to the clipboard")
+	addJWTFlags(jwtMintCmd)
+
+	jwtVerifyCmd.PersistentFlags().StringP("jwt-secret", "j", "", "The JWT secret for the add-on")
+	jwtVerifyCmd.PersistentFlags().String("jwt-public-key", "", "A PEM file with the RSA public key RS256 tokens are verified with, instead of the one of --jwt-private-key")
+	addJWTFlags(jwtVerifyCmd)
+}
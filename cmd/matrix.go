@@ -0,0 +1,317 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// matrixWaitDelay is how long the combinations get to clean up and report
+// after being interrupted, e.g. by Ctrl-C or --deadline, before being killed.
+const matrixWaitDelay = 30 * time.Second
+
+// matrixDimension is one --matrix flag, e.g. chain=ethereum,solana.
+type matrixDimension struct {
+	Flag   string
+	Values []string
+}
+
+// matrixCell is one combination of a matrix run, e.g. chain=ethereum network=mainnet.
+type matrixCell struct {
+	Values  []string
+	Summary *summaryRecord
+	Err     error
+	Log     []byte
+}
+
+func (c *matrixCell) label(dimensions []matrixDimension) string {
+	var parts []string
+	for i, dimension := range dimensions {
+		parts = append(parts, dimension.Flag+"="+c.Values[i])
+	}
+	return strings.Join(parts, " ")
+}
+
+// addMatrixFlags adds the flags that run a command for every combination of flag values.
+func addMatrixFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringArray("matrix", []string{}, "Run the command for every combination of values, as flag=value1,value2 (can be repeated, e.g. --matrix chain=ethereum,polygon --matrix network=mainnet,testnet)")
+	cmd.PersistentFlags().Int("workers", 4, "The number of --matrix combinations to run in parallel")
+}
+
+// matrixRequested reports whether the command was called with --matrix.
+func matrixRequested(cmd *cobra.Command) bool {
+	values, _ := cmd.Flags().GetStringArray("matrix")
+	return len(values) > 0
+}
+
+// parseMatrix parses the --matrix flags, checking that each one names a flag of the command.
+func parseMatrix(cmd *cobra.Command, values []string) ([]matrixDimension, error) {
+	var dimensions []matrixDimension
+	seen := map[string]bool{}
+	for _, value := range values {
+		name, list, ok := strings.Cut(value, "=")
+		if !ok || name == "" || list == "" {
+			return nil, fmt.Errorf("invalid --matrix %q, expected flag=value1,value2 (e.g. chain=ethereum,polygon)", value)
+		}
+		if cmd.Flags().Lookup(name) == nil || name == "matrix" || name == "workers" {
+			return nil, fmt.Errorf("invalid --matrix %q: %s is not a flag of the %s command", value, name, cmd.Name())
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("invalid --matrix %q: %s is already part of the matrix", value, name)
+		}
+		seen[name] = true
+
+		dimension := matrixDimension{Flag: name}
+		for _, v := range strings.Split(list, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				dimension.Values = append(dimension.Values, v)
+			}
+		}
+		if len(dimension.Values) == 0 {
+			return nil, fmt.Errorf("invalid --matrix %q: %s has no values", value, name)
+		}
+		dimensions = append(dimensions, dimension)
+	}
+	return dimensions, nil
+}
+
+// matrixCells returns every combination of the dimensions' values, varying the last dimension fastest.
+func matrixCells(dimensions []matrixDimension) []*matrixCell {
+	cells := []*matrixCell{{}}
+	for _, dimension := range dimensions {
+		var next []*matrixCell
+		for _, cell := range cells {
+			for _, value := range dimension.Values {
+				values := append(append([]string{}, cell.Values...), value)
+				next = append(next, &matrixCell{Values: values})
+			}
+		}
+		cells = next
+	}
+	return cells
+}
+
+// runMatrix runs the command once per --matrix combination, each in its own
+// process, with up to --workers of them in parallel. Their checks are grouped
+// by combination in the results, and a grid of results is printed at the end.
+func runMatrix(cmd *cobra.Command) {
+	printHeader(strings.ToUpper(cmd.Name()) + " MATRIX")
+	verbose := cmd.Flag("verbose").Value.String() == "true"
+	values, _ := cmd.Flags().GetStringArray("matrix")
+	dimensions, err := parseMatrix(cmd, values)
+	if err != nil {
+		color.Red("%s", err)
+		os.Exit(1)
+	}
+	workers, _ := cmd.Flags().GetInt("workers")
+	if workers < 1 {
+		workers = 1
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		color.Red("Could not find the qn-marketplace-cli executable to run the matrix with: %s", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := commandContext(cmd)
+	defer cancel()
+	results := newResults(cmd, cmd.Name())
+	args := matrixArgs(cmd, dimensions)
+	cells := matrixCells(dimensions)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan *matrixCell)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for cell := range queue {
+				cell.run(ctx, executable, args, dimensions)
+				mu.Lock()
+				cell.print(dimensions, verbose)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, cell := range cells {
+		queue <- cell
+	}
+	close(queue)
+	wg.Wait()
+
+	for _, cell := range cells {
+		for _, check := range cell.checks(cmd.Name()) {
+			check.Group = cell.label(dimensions)
+			results.add(check)
+		}
+	}
+	printMatrixGrid(dimensions, cells)
+	results.finish()
+}
+
+// matrixArgs returns the arguments to run the command with in each process:
+// the flags it was called with or got from the config file, minus the matrix
+// ones and the output ones, which the parent process handles.
+func matrixArgs(cmd *cobra.Command, dimensions []matrixDimension) []string {
+	skip := map[string]bool{"matrix": true, "workers": true, "output": true, "report": true, "config": true, "profile": true, "no-config": true}
+	for _, dimension := range dimensions {
+		skip[dimension.Flag] = true
+	}
+
+	args := strings.Fields(cmd.CommandPath())[1:]
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if skip[f.Name] {
+			return
+		}
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			if len(slice.GetSlice()) == 0 {
+				args = append(args, "--"+f.Name+"=")
+			}
+			for _, value := range slice.GetSlice() {
+				args = append(args, "--"+f.Name+"="+value)
+			}
+			return
+		}
+		args = append(args, "--"+f.Name+"="+f.Value.String())
+	})
+	return append(args, "--no-config", "--output="+outputJSON)
+}
+
+func (c *matrixCell) run(ctx context.Context, executable string, args []string, dimensions []matrixDimension) {
+	args = append([]string{}, args...)
+	for i, dimension := range dimensions {
+		args = append(args, "--"+dimension.Flag+"="+c.Values[i])
+	}
+
+	var stdout, stderr bytes.Buffer
+	child := exec.CommandContext(ctx, executable, args...)
+	// Interrupt rather than kill the command, so it can clean up and report
+	child.Cancel = func() error {
+		return child.Process.Signal(os.Interrupt)
+	}
+	child.WaitDelay = matrixWaitDelay
+	child.Stdout = &stdout
+	child.Stderr = &stderr
+	runErr := child.Run()
+	c.Log = stderr.Bytes()
+
+	// The command exits with a non-zero code when a check failed, which the summary already says
+	var summary summaryRecord
+	if err := json.Unmarshal(stdout.Bytes(), &summary); err != nil {
+		if runErr == nil {
+			runErr = err
+		}
+		c.Err = fmt.Errorf("the command did not report any results (%s): %s", runErr, strings.TrimSpace(stderr.String()))
+		return
+	}
+	c.Summary = &summary
+}
+
+func (c *matrixCell) print(dimensions []matrixDimension, verbose bool) {
+	label := c.label(dimensions)
+	switch {
+	case c.Err != nil:
+		color.Red("  ✘ %s: %s", label, c.Err)
+	case c.Summary.Failed > 0:
+		color.Red("  ✘ %s: %d of %d checks failed", label, c.Summary.Failed, c.Summary.Passed+c.Summary.Failed)
+	default:
+		color.Green("  ✓ %s: %d checks passed", label, c.Summary.Passed)
+	}
+	if verbose && len(c.Log) > 0 {
+		fmt.Printf("\n%s\n", c.Log)
+	}
+}
+
+// checks returns the checks made by the combination's process, or a failed
+// check when the process didn't report any.
+func (c *matrixCell) checks(command string) []checkResult {
+	if c.Summary == nil {
+		return []checkResult{{Name: command + " ran", Message: c.Err.Error()}}
+	}
+	var checks []checkResult
+	for _, record := range c.Summary.Checks {
+		checks = append(checks, checkResult{
+			Name:      record.Name,
+			Passed:    record.Verdict == "pass",
+			Message:   record.Message,
+			Severity:  record.Severity,
+			Duration:  time.Duration(record.DurationSeconds * float64(time.Second)),
+			Exchanges: record.Exchanges,
+		})
+	}
+	return checks
+}
+
+func (c *matrixCell) verdict() string {
+	switch {
+	case c.Summary == nil:
+		return "ERROR"
+	case c.Summary.Failed > 0:
+		return fmt.Sprintf("FAIL %d/%d", c.Summary.Passed, c.Summary.Passed+c.Summary.Failed)
+	default:
+		return fmt.Sprintf("PASS %d/%d", c.Summary.Passed, c.Summary.Passed)
+	}
+}
+
+// printMatrixGrid prints the verdict of every combination, with a column per
+// value of the last dimension and a row per combination of the others.
+func printMatrixGrid(dimensions []matrixDimension, cells []*matrixCell) {
+	if outputFormat != outputText {
+		return
+	}
+	fmt.Println()
+	printHeader("RESULTS")
+
+	last := dimensions[len(dimensions)-1]
+	columns := len(last.Values)
+	table := [][]string{{""}}
+	for _, value := range last.Values {
+		table[0] = append(table[0], last.Flag+"="+value)
+	}
+	for i := 0; i < len(cells); i += columns {
+		row := []string{(&matrixCell{Values: cells[i].Values[:len(dimensions)-1]}).label(dimensions[:len(dimensions)-1])}
+		for _, cell := range cells[i : i+columns] {
+			row = append(row, cell.verdict())
+		}
+		table = append(table, row)
+	}
+
+	// Colors are added after padding, since tabwriter would count their escape codes
+	widths := make([]int, columns+1)
+	for _, row := range table {
+		for i, value := range row {
+			if len(value) > widths[i] {
+				widths[i] = len(value)
+			}
+		}
+	}
+	for r, row := range table {
+		line := "  "
+		for i, value := range row {
+			padded := fmt.Sprintf("%-*s   ", widths[i], value)
+			switch {
+			case r == 0 || i == 0:
+				line += padded
+			case strings.HasPrefix(value, "PASS"):
+				line += color.GreenString(padded)
+			default:
+				line += color.RedString(padded)
+			}
+		}
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+}
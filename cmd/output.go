@@ -0,0 +1,158 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+const (
+	outputText   = "text"
+	outputJSON   = "json"
+	outputNDJSON = "ndjson"
+)
+
+// outputFormat is the --output format of the current run.
+var outputFormat = outputText
+
+// machineOutput is where JSON records are written. In the json and ndjson
+// formats, everything else commands print goes to stderr instead so that
+// stdout can be piped into jq.
+var machineOutput io.Writer = os.Stdout
+
+// recordsAnnotation marks the commands that write a single record of their
+// own rather than check results, e.g. instances list. They print nothing else
+// in the json and ndjson formats, so stdout is left alone for them.
+const recordsAnnotation = "qn-marketplace-cli/records"
+
+func setupOutput(cmd *cobra.Command) error {
+	format, _ := cmd.Flags().GetString("output")
+	switch format {
+	case outputText:
+	case outputJSON, outputNDJSON:
+		machineOutput = os.Stdout
+		if _, ok := cmd.Annotations[recordsAnnotation]; !ok {
+			os.Stdout = os.Stderr
+		}
+		color.Output = os.Stderr
+		color.NoColor = true
+	default:
+		return fmt.Errorf("unknown output format %q, expected text, json or ndjson", format)
+	}
+	outputFormat = format
+	return nil
+}
+
+// printHeader prints the colored banner at the top of a command's output.
+func printHeader(title string) {
+	if outputFormat != outputText {
+		return
+	}
+	header := color.New(color.FgWhite, color.BgBlue).SprintFunc()
+	fmt.Printf("%s\n\n", header("        "+title+"        "))
+}
+
+// checkRecord is the machine-readable form of a check.
+type checkRecord struct {
+	Type            string     `json:"type"`
+	Suite           string     `json:"suite"`
+	Group           string     `json:"group,omitempty"`
+	Name            string     `json:"name"`
+	Verdict         string     `json:"verdict"`
+	Message         string     `json:"message,omitempty"`
+	Severity        string     `json:"severity,omitempty"`
+	DurationSeconds float64    `json:"duration_seconds"`
+	Exchanges       []exchange `json:"exchanges"`
+}
+
+// summaryRecord is the machine-readable outcome of a whole run.
+type summaryRecord struct {
+	Type            string        `json:"type"`
+	Suite           string        `json:"suite"`
+	Verdict         string        `json:"verdict"`
+	Passed          int           `json:"passed"`
+	Failed          int           `json:"failed"`
+	Started         time.Time     `json:"started"`
+	DurationSeconds float64       `json:"duration_seconds"`
+	Checks          []checkRecord `json:"checks,omitempty"`
+}
+
+func newCheckRecord(suite string, check checkResult) checkRecord {
+	exchanges := check.Exchanges
+	if exchanges == nil {
+		exchanges = []exchange{}
+	}
+	return checkRecord{
+		Type:            "check",
+		Suite:           suite,
+		Group:           check.Group,
+		Name:            check.Name,
+		Verdict:         verdict(check.Passed),
+		Message:         check.Message,
+		Severity:        check.Severity,
+		DurationSeconds: check.Duration.Seconds(),
+		Exchanges:       exchanges,
+	}
+}
+
+func newSummaryRecord(r *results) summaryRecord {
+	summary := summaryRecord{Type: "summary", Suite: r.Suite, Started: r.Started, DurationSeconds: time.Since(r.Started).Seconds()}
+	for _, check := range r.Checks {
+		if check.Passed {
+			summary.Passed++
+		} else {
+			summary.Failed++
+		}
+	}
+	summary.Verdict = verdict(summary.Failed == 0)
+	return summary
+}
+
+func verdict(passed bool) string {
+	if passed {
+		return "pass"
+	}
+	return "fail"
+}
+
+// emitCheck streams a check as soon as it completes in the ndjson format.
+func emitCheck(suite string, check checkResult) error {
+	if outputFormat == outputNDJSON {
+		return writeRecord(newCheckRecord(suite, check))
+	}
+	return nil
+}
+
+// emitResults writes the outcome of the run: a final summary line in the
+// ndjson format, or a single document with every check in the json format.
+func emitResults(r *results) error {
+	summary := newSummaryRecord(r)
+	switch outputFormat {
+	case outputNDJSON:
+		return writeRecord(summary)
+	case outputJSON:
+		summary.Checks = []checkRecord{}
+		for _, check := range r.Checks {
+			summary.Checks = append(summary.Checks, newCheckRecord(r.Suite, check))
+		}
+		return writeRecord(summary)
+	}
+	return nil
+}
+
+func writeRecord(record interface{}) error {
+	encoder := json.NewEncoder(machineOutput)
+	encoder.SetEscapeHTML(false)
+	if outputFormat == outputJSON {
+		encoder.SetIndent("", "  ")
+	}
+	return encoder.Encode(record)
+}
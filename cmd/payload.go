@@ -0,0 +1,131 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// addPayloadFlags adds the flags overriding the payload a command sends.
+func addPayloadFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("payload", "", "JSON to merge over the generated payload, or - to read it from stdin. Fields set to null are left out")
+	cmd.PersistentFlags().String("payload-file", "", "A JSON file (or - for stdin) to merge over the generated payload, like --payload")
+	cmd.PersistentFlags().StringArray("var", []string{}, "Set a variable for the payload template, as key=value (can be repeated)")
+}
+
+// requestPayload returns the payload to send for request, a pointer to one of
+// the request structs: the request itself, or the --payload or --payload-file
+// JSON merged over it. The JSON can be a Go template using the request's
+// fields (e.g. {{ .quicknode_id }}), --var values and the uuid, now and env
+// functions. request is updated with the merged values its fields can hold.
+// It exits if the payload can't be read.
+func requestPayload(cmd *cobra.Command, request interface{}) interface{} {
+	payload, err := mergePayload(cmd, request)
+	if err != nil {
+		color.Red("%s", err)
+		os.Exit(1)
+	}
+	return payload
+}
+
+func mergePayload(cmd *cobra.Command, request interface{}) (interface{}, error) {
+	raw, source, err := readPayload(cmd)
+	if err != nil || raw == "" {
+		return request, err
+	}
+
+	encoded, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil, err
+	}
+
+	vars := map[string]string{}
+	for key, value := range fields {
+		switch value.(type) {
+		case string, float64, bool:
+			vars[strings.ReplaceAll(key, "-", "_")] = fmt.Sprint(value)
+		}
+	}
+	values, _ := cmd.Flags().GetStringArray("var")
+	for _, v := range values {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid --var %q, expected key=value", v)
+		}
+		vars[key] = value
+	}
+	expanded, err := expandTemplate(raw, vars)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+
+	var override interface{}
+	if err := json.Unmarshal([]byte(expanded), &override); err != nil {
+		return nil, fmt.Errorf("%s is not valid JSON: %w", source, err)
+	}
+	overrides, ok := override.(map[string]interface{})
+	if !ok {
+		// Anything but an object replaces the payload altogether
+		return override, nil
+	}
+	for key, value := range overrides {
+		if value == nil {
+			delete(fields, key)
+		} else {
+			fields[key] = value
+		}
+	}
+
+	// Update the request with what is actually sent: the fields it can hold,
+	// leaving out the ones deleted or of the wrong type
+	merged, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	sent := reflect.New(reflect.TypeOf(request).Elem())
+	var typeErr *json.UnmarshalTypeError
+	if err := json.Unmarshal(merged, sent.Interface()); err != nil && !errors.As(err, &typeErr) {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+	reflect.ValueOf(request).Elem().Set(sent.Elem())
+	return fields, nil
+}
+
+// readPayload returns the --payload or --payload-file JSON and where it came
+// from, or "" when neither is set.
+func readPayload(cmd *cobra.Command) (string, string, error) {
+	inline := cmd.Flag("payload").Value.String()
+	path := cmd.Flag("payload-file").Value.String()
+	switch {
+	case inline != "" && path != "":
+		return "", "", errors.New("please provide either --payload or --payload-file, not both")
+	case inline == "-" || path == "-":
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", "", fmt.Errorf("could not read the payload from stdin: %w", err)
+		}
+		return string(data), "the payload read from stdin", nil
+	case path != "":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", "", err
+		}
+		return string(data), path, nil
+	default:
+		return inline, "--payload", nil
+	}
+}
@@ -0,0 +1,324 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/quiknode-labs/qn-marketplace-cli/marketplace"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/cobra"
+)
+
+// plansCmd represents the plans command
+var plansCmd = &cobra.Command{
+	Use:   "plans",
+	Short: "Allows you to test upgrading and downgrading between your add-on's plans",
+	Long: `Use this command to make sure your update API handles every plan change.
+
+Declare your add-on's plan slugs with --plans, from the cheapest to the most expensive. The add-on is
+provisioned on the first plan, then upgraded one plan at a time up to the last one and downgraded back to
+the first, before being deprovisioned. With --transitions all, it is instead updated so that every upgrade
+and downgrade between two plans is made exactly once, which takes n·(n−1) updates for n plans, e.g. 90
+for 10 plans, instead of 2·(n−1).
+
+To also check that plan-specific RPC methods are enabled and disabled along with the plan, pass --rpc-url
+and declare the methods of each plan with --plan-methods (e.g. --plan-methods pro=qn_fancyMethod,qn_otherMethod).
+After each plan change, the methods of the current plan must be served and the methods of other plans must
+be refused, either with a 4xx or with a JSON-RPC error.
+
+It uses the same base URL and routes as pudd.
+`,
+	Args: cobra.OnlyValidArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		printHeader("PLANS")
+		verbose := cmd.Flag("verbose").Value.String() == "true"
+		keepGoing := cmd.Flag("continue-on-failure").Value.String() == "true"
+		baseUrl := cmd.Flag("base-url").Value.String()
+		if baseUrl == "" {
+			fmt.Print("Please provide a base URL for the provisioning API via the --base-url flag\n")
+			os.Exit(1)
+		}
+		plans, _ := cmd.Flags().GetStringSlice("plans")
+		if len(plans) < 2 {
+			color.Red("Please provide at least 2 plan slugs via the --plans flag\n")
+			os.Exit(1)
+		}
+		seen := map[string]bool{}
+		for _, plan := range plans {
+			if seen[plan] {
+				color.Red("Plan %s appears more than once in --plans\n", plan)
+				os.Exit(1)
+			}
+			seen[plan] = true
+		}
+		var transitions [][2]string
+		switch mode := cmd.Flag("transitions").Value.String(); mode {
+		case planTransitionsAdjacent:
+			transitions = adjacentPlanTransitions(plans)
+		case planTransitionsAll:
+			transitions = allPlanTransitions(plans)
+		default:
+			color.Red("Unknown --transitions %q, expected %s or %s\n", mode, planTransitionsAdjacent, planTransitionsAll)
+			os.Exit(1)
+		}
+		methodFlags, _ := cmd.Flags().GetStringArray("plan-methods")
+		planMethods, err := parsePlanMethods(methodFlags, plans)
+		if err != nil {
+			color.Red("%s", err)
+			os.Exit(1)
+		}
+		rpcURL := cmd.Flag("rpc-url").Value.String()
+		if len(planMethods) > 0 && rpcURL == "" {
+			color.Red("Please provide a URL to call the plan methods on via the --rpc-url flag\n")
+			os.Exit(1)
+		}
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		results := newResults(cmd, "plans")
+		client := newClient(cmd, marketplace.WithBaseURL(baseUrl), results.recording())
+		routes, err := newRoutes(cmd, client)
+		if err != nil {
+			color.Red("%s", err)
+			os.Exit(1)
+		}
+		quicknodeId := cmd.Flag("quicknode-id").Value.String()
+		endpointId := cmd.Flag("endpoint-id").Value.String()
+		methods := &planMethodCheck{
+			client:  client,
+			rpcURL:  rpcURL,
+			methods: planMethods,
+			instance: marketplace.InstanceHeaders{
+				QuickNodeId: quicknodeId,
+				EndpointId:  endpointId,
+				Chain:       cmd.Flag("chain").Value.String(),
+				Network:     cmd.Flag("network").Value.String(),
+			},
+		}
+
+		// Provision on the first plan
+		provisionUrl := routes.url("provision", quicknodeId, endpointId)
+		request := provisionRequest(cmd, quicknodeId, endpointId, plans[0])
+		if verbose {
+			requestJson, _ := json.MarshalIndent(request, "", "  ")
+			color.Blue("\n\n→ POST %s:\n", provisionUrl)
+			fmt.Printf("%s\n", requestJson)
+		}
+		provisioned := results.run(fmt.Sprintf("Provision on plan %s was successful", plans[0]), keepGoing, func() error {
+			_, err := client.Provision(ctx, provisionUrl, request)
+			return err
+		})
+		if provisioned {
+			methods.check(ctx, results, keepGoing, plans[0], "provision on plan "+plans[0])
+		}
+
+		// Then go through every plan change
+		updateUrl := routes.url("update", quicknodeId, endpointId)
+		for _, transition := range transitions {
+			from, to := transition[0], transition[1]
+			payload := updateRequest(cmd, quicknodeId, endpointId, to)
+			if verbose {
+				requestJson, _ := json.MarshalIndent(payload, "", "  ")
+				color.Blue("\n\n→ PUT %s (from plan %s to %s):\n", updateUrl, from, to)
+				fmt.Printf("%s\n", requestJson)
+			}
+			updated := results.run(fmt.Sprintf("Update from plan %s to %s was successful", from, to), keepGoing, func() error {
+				_, err := client.Update(ctx, updateUrl, payload)
+				return err
+			})
+			if updated {
+				methods.check(ctx, results, keepGoing, to, fmt.Sprintf("update from plan %s to %s", from, to))
+			}
+		}
+
+		// Clean up
+		deprovisionUrl := routes.url("deprovision", quicknodeId, endpointId)
+		deprovisionPayload := deprovisionRequest(cmd, quicknodeId)
+		if verbose {
+			requestJson, _ := json.MarshalIndent(deprovisionPayload, "", "  ")
+			color.Blue("\n\n→ DELETE %s:\n", deprovisionUrl)
+			fmt.Printf("%s\n", requestJson)
+		}
+		results.run("Deprovision was successful", keepGoing, func() error {
+			_, err := client.Deprovision(ctx, deprovisionUrl, deprovisionPayload)
+			return err
+		})
+
+		if keepGoing {
+			results.printSummary()
+		}
+		results.finish()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(plansCmd)
+
+	plansCmd.PersistentFlags().StringP("base-url", "u", "", "The base URL of the add-on's provisioning API")
+
+	// Note: basic auth defaults to username = Aladdin and password = open sesame
+	plansCmd.PersistentFlags().String("basic-auth", "QWxhZGRpbjpvcGVuIHNlc2FtZQ==", "The basic auth credentials for the add-on. Defaults to username = Aladdin and password = open sesame")
+
+	addRouteFlags(plansCmd)
+
+	plansCmd.PersistentFlags().StringSlice("plans", []string{}, "The add-on's plan slugs, e.g. discover,build,scale. The add-on is provisioned on the first one")
+	plansCmd.PersistentFlags().String("transitions", planTransitionsAdjacent, "The plan changes to make: adjacent to upgrade and downgrade between neighbouring plans, or all for every pair of plans (n·(n−1) updates for n plans)")
+	plansCmd.PersistentFlags().StringArray("plan-methods", []string{}, "The RPC methods only available on a plan, as plan=method1,method2 (can be repeated)")
+	plansCmd.PersistentFlags().String("rpc-url", "", "The URL to call the plan methods on")
+
+	plansCmd.PersistentFlags().StringP("quicknode-id", "q", uuid.NewV4().String(), "The QuickNode ID to provision the add-on for (optional)")
+	plansCmd.PersistentFlags().StringP("endpoint-id", "e", uuid.NewV4().String(), "The endpoint ID to provision the add-on for (optional)")
+	plansCmd.PersistentFlags().StringP("endpoint-url", "l", "https://long-late-firefly.quiknode.pro/4bb1e6b2dec8294938b6fdfdb7cf0cf70c4e97a2/", "The endpoint URL to provision the add-on for (optional - defaults to an ethereum mainnet endpoint")
+	plansCmd.PersistentFlags().StringP("wss-url", "w", "wss://long-late-firefly.quiknode.pro/4bb1e6b2dec8294938b6fdfdb7cf0cf70c4e97a2/", "The WSS URL to provision the add-on for (optional - defaults to an ethereum mainnet endpoint")
+	plansCmd.PersistentFlags().StringP("chain", "c", "ethereum", "The chain to provision the add-on for")
+	plansCmd.PersistentFlags().StringP("network", "n", "mainnet", "The network to provision the add-on for")
+	plansCmd.PersistentFlags().StringP("add-on-id", "i", "33", "The ID of the add-on to provision")
+	plansCmd.PersistentFlags().StringP("add-on-slug", "s", "myslug", "The slug of the add-on to provision")
+	addWhitelistFlags(plansCmd)
+
+	plansCmd.PersistentFlags().Bool("continue-on-failure", false, "Run every step even if an earlier one failed, then print a summary of all the results")
+}
+
+// parsePlanMethods parses the --plan-methods flags, e.g. pro=qn_fancyMethod,qn_otherMethod.
+func parsePlanMethods(values []string, plans []string) (map[string][]string, error) {
+	methods := map[string][]string{}
+	for _, value := range values {
+		plan, list, ok := strings.Cut(value, "=")
+		if !ok || plan == "" || list == "" {
+			return nil, fmt.Errorf("invalid --plan-methods %q, expected plan=method1,method2", value)
+		}
+		known := false
+		for _, p := range plans {
+			known = known || p == plan
+		}
+		if !known {
+			return nil, fmt.Errorf("invalid --plan-methods %q: %s is not one of the --plans", value, plan)
+		}
+		for _, method := range strings.Split(list, ",") {
+			if method = strings.TrimSpace(method); method != "" {
+				methods[plan] = append(methods[plan], method)
+			}
+		}
+	}
+	return methods, nil
+}
+
+// The --transitions the plans command can make.
+const (
+	planTransitionsAdjacent = "adjacent"
+	planTransitionsAll      = "all"
+)
+
+// adjacentPlanTransitions returns plan changes that upgrade one plan at a
+// time from the first plan to the last, then downgrade back to the first.
+func adjacentPlanTransitions(plans []string) [][2]string {
+	var transitions [][2]string
+	for i := 0; i < len(plans)-1; i++ {
+		transitions = append(transitions, [2]string{plans[i], plans[i+1]})
+	}
+	for i := len(plans) - 1; i > 0; i-- {
+		transitions = append(transitions, [2]string{plans[i], plans[i-1]})
+	}
+	return transitions
+}
+
+// allPlanTransitions returns plan changes that go through every upgrade and
+// downgrade between two plans exactly once, starting from the first plan.
+// Each change starts from the plan the previous one ended on.
+func allPlanTransitions(plans []string) [][2]string {
+	// This is an Eulerian circuit of the complete directed graph of plans,
+	// found with Hierholzer's algorithm.
+	next := make([]int, len(plans))
+	stack := []int{0}
+	var circuit []int
+	for len(stack) > 0 {
+		from := stack[len(stack)-1]
+		if next[from] == from {
+			next[from]++
+		}
+		if next[from] < len(plans) {
+			stack = append(stack, next[from])
+			next[from]++
+			continue
+		}
+		circuit = append(circuit, from)
+		stack = stack[:len(stack)-1]
+	}
+
+	var transitions [][2]string
+	for i := len(circuit) - 1; i > 0; i-- {
+		transitions = append(transitions, [2]string{plans[circuit[i]], plans[circuit[i-1]]})
+	}
+	return transitions
+}
+
+// planMethodCheck calls the plan-specific RPC methods to check that only the
+// methods of the current plan are served.
+type planMethodCheck struct {
+	client   *marketplace.Client
+	rpcURL   string
+	methods  map[string][]string
+	instance marketplace.InstanceHeaders
+}
+
+func (p *planMethodCheck) check(ctx context.Context, results *results, keepGoing bool, current string, step string) {
+	var plans []string
+	for plan := range p.methods {
+		plans = append(plans, plan)
+	}
+	sort.Strings(plans)
+
+	for _, plan := range plans {
+		available := plan == current
+		for _, method := range p.methods[plan] {
+			name := fmt.Sprintf("%s is unavailable after %s", method, step)
+			if available {
+				name = fmt.Sprintf("%s is available after %s", method, step)
+			}
+			results.run(name, keepGoing, func() error {
+				request := marketplace.RPCRequest{Method: method, Params: []interface{}{}, ID: uuid.NewV4().String()}
+				statusCode, body, err := p.client.RPC(ctx, p.rpcURL, request, p.instance)
+				var decodeErr *marketplace.DecodeError
+				if err != nil && !(errors.As(err, &decodeErr) && !available) {
+					return err
+				}
+				return planMethodError(statusCode, body, available)
+			})
+		}
+	}
+}
+
+// planMethodError checks the response to a plan-specific RPC method. Methods
+// can be refused with a 4xx or with a JSON-RPC error.
+func planMethodError(statusCode int, body interface{}, available bool) error {
+	response, _ := body.(map[string]interface{})
+	rpcError, hasError := response["error"]
+	rpcErrorJson, _ := json.Marshal(rpcError)
+
+	if statusCode >= 500 {
+		return fmt.Errorf("the add-on crashed with %d %s", statusCode, http.StatusText(statusCode))
+	}
+	if available {
+		if statusCode != http.StatusOK {
+			return fmt.Errorf("the add-on responded with %d %s", statusCode, http.StatusText(statusCode))
+		}
+		if hasError {
+			return fmt.Errorf("the add-on responded with a JSON-RPC error: %s", rpcErrorJson)
+		}
+		return nil
+	}
+	if statusCode >= 400 || hasError {
+		return nil
+	}
+	return fmt.Errorf("the add-on still served the method with %d %s", statusCode, http.StatusText(statusCode))
+}
@@ -0,0 +1,76 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPlanTransitions(t *testing.T) {
+	tests := []struct {
+		name        string
+		transitions func(plans []string) [][2]string
+		// want returns the number of times a change from one plan to another is expected
+		want func(from int, to int) int
+	}{
+		{
+			name:        "adjacent",
+			transitions: adjacentPlanTransitions,
+			want: func(from int, to int) int {
+				if from-to == 1 || to-from == 1 {
+					return 1
+				}
+				return 0
+			},
+		},
+		{
+			name:        "all",
+			transitions: allPlanTransitions,
+			want: func(from int, to int) int {
+				if from != to {
+					return 1
+				}
+				return 0
+			},
+		},
+	}
+	for _, test := range tests {
+		for n := 2; n <= 7; n++ {
+			t.Run(fmt.Sprintf("%s/%d plans", test.name, n), func(t *testing.T) {
+				plans := make([]string, n)
+				index := map[string]int{}
+				for i := range plans {
+					plans[i] = fmt.Sprintf("plan%d", i)
+					index[plans[i]] = i
+				}
+
+				transitions := test.transitions(plans)
+				counts := map[[2]string]int{}
+				current := plans[0]
+				for i, transition := range transitions {
+					if transition[0] != current {
+						t.Errorf("transition #%d starts from %s, but the previous one ended on %s", i+1, transition[0], current)
+					}
+					counts[transition]++
+					current = transition[1]
+				}
+
+				total := 0
+				for from := range plans {
+					for to := range plans {
+						want := test.want(from, to)
+						total += want
+						if got := counts[[2]string{plans[from], plans[to]}]; got != want {
+							t.Errorf("%s → %s is made %d times, want %d", plans[from], plans[to], got, want)
+						}
+					}
+				}
+				if len(transitions) != total {
+					t.Errorf("got %d transitions, want %d", len(transitions), total)
+				}
+			})
+		}
+	}
+}
@@ -23,15 +23,19 @@ var provisionCmd = &cobra.Command{
 Learn more at https://www.quicknode.com/guides/quicknode-products/marketplace/how-provisioning-works-for-marketplace-partners/`,
 	Args: cobra.OnlyValidArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		header := color.New(color.FgWhite, color.BgBlue).SprintFunc()
-		fmt.Printf("%s\n\n", header("        PROVISION        "))
+		printHeader("PROVISION")
 		verbose := cmd.Flag("verbose").Value.String() == "true"
 		url := cmd.Flag("url").Value.String()
 		if url == "" {
 			fmt.Print("Please provide a URL for the provision API via the --url flag\n")
 			os.Exit(1)
 		}
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		results := newResults(cmd, "provision")
+		client := newClient(cmd, results.recording())
 
+		referers, contractAddresses := whitelist(cmd)
 		request := marketplace.ProvisionRequest{
 			QuickNodeId:       cmd.Flag("quicknode-id").Value.String(),
 			EndpointId:        cmd.Flag("endpoint-id").Value.String(),
@@ -40,45 +44,43 @@ Learn more at https://www.quicknode.com/guides/quicknode-products/marketplace/ho
 			Plan:              cmd.Flag("plan").Value.String(),
 			WSSURL:            cmd.Flag("wss-url").Value.String(),
 			HTTPURL:           cmd.Flag("endpoint-url").Value.String(),
-			Referers:          []string{"https://quicknode.com"},
-			ContractAddresses: []string{"0x4d224452801ACEd8B2F0aebE155379bb5D594381"},
+			Referers:          referers,
+			ContractAddresses: contractAddresses,
 			AddOnSlug:         cmd.Flag("add-on-slug").Value.String(),
 			AddOnId:           cmd.Flag("add-on-id").Value.String(),
 		}
+		payload := requestPayload(cmd, &request)
 
 		// Check that it is protected by basic auth
-		isProtectedByBasicAuth, err := marketplace.RequiresBasicAuth(url, "POST")
-		if err != nil {
-			color.Red("%s", err)
-			os.Exit(1)
-		}
-		if !isProtectedByBasicAuth {
-			color.Red("  ✘ The provision API is not protected by basic auth.")
-			os.Exit(1)
-		}
+		results.run("Provision API is protected by basic auth", false, func() error {
+			return checkBasicAuth(ctx, client, url, "POST", "provision")
+		})
 
 		if verbose {
 			color.Blue("→ POST %s:\n", url)
 		}
-		requestJson, _ := json.MarshalIndent(request, "", "  ")
+		requestJson, _ := json.MarshalIndent(payload, "", "  ")
 		if verbose {
 			fmt.Printf("%s\n", requestJson)
 		}
 
-		response, err := marketplace.Provision(url, request, cmd.Flag("basic-auth").Value.String())
-		if err != nil {
-			color.Red("%s", err)
-			os.Exit(1)
-		}
+		results.run("Provision was successful", false, func() error {
+			var response marketplace.ProvisionResponse
+			if err := client.Call(ctx, "POST", url, payload, &response); err != nil {
+				return err
+			}
 
-		if verbose {
-			fmt.Printf("\nProvision was successful:\n")
-			fmt.Printf("\tStatus: \t\t%s\n", response.Status)
-			fmt.Printf("\tDashboard URL: \t\t%s\n", response.DashboardURL)
-			fmt.Printf("\tAccess URL: \t\t%s\n\n", response.AccessURL)
-		}
+			if verbose {
+				fmt.Printf("\nProvision was successful:\n")
+				fmt.Printf("\tStatus: \t\t%s\n", response.Status)
+				fmt.Printf("\tDashboard URL: \t\t%s\n", response.DashboardURL)
+				fmt.Printf("\tAccess URL: \t\t%s\n\n", response.AccessURL)
+			}
+			recordProvisioned(cmd, url, request, response)
+			return nil
+		})
 
-		color.Green("  ✓ Provision was successful")
+		results.finish()
 	},
 }
 
@@ -99,4 +101,7 @@ func init() {
 	provisionCmd.PersistentFlags().StringP("plan", "p", "discover", "The plan to provision the add-on for")
 	provisionCmd.PersistentFlags().StringP("add-on-id", "i", "33", "The ID of the add-on to provision")
 	provisionCmd.PersistentFlags().StringP("add-on-slug", "s", "myslug", "The slug of the add-on to provision")
+	addPayloadFlags(provisionCmd)
+	addWhitelistFlags(provisionCmd)
+	addInstanceNameFlag(provisionCmd)
 }
@@ -7,6 +7,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/fatih/color"
@@ -21,55 +23,87 @@ var puddCmd = &cobra.Command{
 	Short: "Allows you to test your add-on's entire provisioning workflows (all four actions)",
 	Long: `Use this command to make sure your API implementation for provisioning workflows works across the board.
 
-This only works if your API URLs ends with:
+By default, the tool appends these paths to the base-url you pass to it to call your API:
   - /provision
   - /update
   - /deactivate_endpoint
   - /deprovision
 
-The tool will use the base-url you pass to it and append these to the base URL to call your API.
+If your routes are laid out differently, change the path of every action with --route-template (e.g.
+"/v1/{action}" or "/accounts/{quicknode-id}/{action}"), or the URL of one action with --provision-url,
+--update-url, --deactivate-url or --deprovision-url, which can be full URLs or paths relative to the
+base URL. Templates can use the {action}, {quicknode-id}, {endpoint-id}, {chain} and {network} placeholders.
+
+By default the command stops at the first failed check. With --continue-on-failure every check is run,
+deprovision is still attempted to clean up, and a summary of all the results is printed at the end.
+
+Since QuickNode retries these calls in production, each action is sent twice and the second response
+must match the first one (status, dashboard-url and access-url).
+
+With --rpc-url and/or --rest-url, an RPC or REST call is also made with the endpoint's instance headers
+after each step: it must be served after provision and update, and refused with a 4xx after deactivate
+and deprovision.
 `,
 	Args: cobra.OnlyValidArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		header := color.New(color.FgWhite, color.BgBlue).SprintFunc()
-		fmt.Printf("%s\n\n", header("        PUDD        "))
+		if matrixRequested(cmd) {
+			runMatrix(cmd)
+			return
+		}
+		printHeader("PUDD")
 		verbose := cmd.Flag("verbose").Value.String() == "true"
+		keepGoing := cmd.Flag("continue-on-failure").Value.String() == "true"
 		baseUrl := cmd.Flag("base-url").Value.String()
 		if baseUrl == "" {
 			fmt.Print("Please provide a base URL for the provisioning API via the --base-url flag\n")
 			os.Exit(1)
 		}
-
-	// First Provision
-	request := marketplace.ProvisionRequest{
-		QuickNodeId:       cmd.Flag("quicknode-id").Value.String(),
-		EndpointId:        cmd.Flag("endpoint-id").Value.String(),
-		Chain:             cmd.Flag("chain").Value.String(),
-		Network:           cmd.Flag("network").Value.String(),
-		Plan:              cmd.Flag("plan").Value.String(),
-		WSSURL:            cmd.Flag("wss-url").Value.String(),
-		HTTPURL:           cmd.Flag("endpoint-url").Value.String(),
-		Referers:          []string{"https://quicknode.com"},
-		ContractAddresses: []string{"0x4d224452801ACEd8B2F0aebE155379bb5D594381"},
-		AddOnSlug:         cmd.Flag("add-on-slug").Value.String(),
-		AddOnId:           cmd.Flag("add-on-id").Value.String(),
-	}
-
-		provisionUrl := baseUrl + "/provision"
-
-		// Check that it is protected by basic auth
-		isProtectedByBasicAuth, err := marketplace.RequiresBasicAuth(provisionUrl, "POST")
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		results := newResults(cmd, "pudd")
+		client := newClient(cmd, marketplace.WithBaseURL(baseUrl), results.recording())
+		routes, err := newRoutes(cmd, client)
 		if err != nil {
 			color.Red("%s", err)
 			os.Exit(1)
 		}
-		if !isProtectedByBasicAuth {
-			color.Red("  ✘ The provision API is not protected by basic auth.")
+		cleanup := newRunCleanup(cmd, client, results, routes)
+		quicknodeId := cmd.Flag("quicknode-id").Value.String()
+		endpointId := cmd.Flag("endpoint-id").Value.String()
+		access, err := newAccessProbe(cmd, client, marketplace.InstanceHeaders{
+			QuickNodeId: quicknodeId,
+			EndpointId:  endpointId,
+			Chain:       cmd.Flag("chain").Value.String(),
+			Network:     cmd.Flag("network").Value.String(),
+		})
+		if err != nil {
+			color.Red("%s", err)
 			os.Exit(1)
-		} else {
-			color.Green("  ✓ Provision API is protected by basic auth.")
 		}
 
+		referers, contractAddresses := whitelist(cmd)
+		// First Provision
+		request := marketplace.ProvisionRequest{
+			QuickNodeId:       cmd.Flag("quicknode-id").Value.String(),
+			EndpointId:        cmd.Flag("endpoint-id").Value.String(),
+			Chain:             cmd.Flag("chain").Value.String(),
+			Network:           cmd.Flag("network").Value.String(),
+			Plan:              cmd.Flag("plan").Value.String(),
+			WSSURL:            cmd.Flag("wss-url").Value.String(),
+			HTTPURL:           cmd.Flag("endpoint-url").Value.String(),
+			Referers:          referers,
+			ContractAddresses: contractAddresses,
+			AddOnSlug:         cmd.Flag("add-on-slug").Value.String(),
+			AddOnId:           cmd.Flag("add-on-id").Value.String(),
+		}
+
+		provisionUrl := routes.url("provision", quicknodeId, endpointId)
+
+		// Check that it is protected by basic auth
+		results.run("Provision API is protected by basic auth", keepGoing, func() error {
+			return checkBasicAuth(ctx, client, provisionUrl, "POST", "provision")
+		})
+
 		if verbose {
 			color.Blue("→ POST %s:\n", provisionUrl)
 		}
@@ -78,156 +112,174 @@ The tool will use the base-url you pass to it and append these to the base URL t
 			fmt.Printf("%s\n", requestJson)
 		}
 
-		provisionResponse, err := marketplace.Provision(provisionUrl, request, cmd.Flag("basic-auth").Value.String())
-		if err != nil {
-			color.Red("%s", err)
-			os.Exit(1)
-		}
-
-		if verbose {
-			fmt.Printf("\nProvision was successful:\n")
-			fmt.Printf("  Status:     %s\n", provisionResponse.Status)
-			fmt.Printf("  Dashboard URL:     %s\n", provisionResponse.DashboardURL)
-			fmt.Printf("  Access URL:     %s\n\n", provisionResponse.AccessURL)
-		}
-
-		color.Green("  ✓ Provision #1 was successful")
+		cleanup.track(provisionUrl, request)
+		var provisionResponse, provisionResponseTwo marketplace.ProvisionResponse
+		provisioned := results.run("Provision #1 was successful", keepGoing, func() error {
+			var err error
+			provisionResponse, err = client.Provision(ctx, provisionUrl, request)
+			if err != nil {
+				return err
+			}
+			if verbose {
+				fmt.Printf("\nProvision was successful:\n")
+				fmt.Printf("  Status:     %s\n", provisionResponse.Status)
+				fmt.Printf("  Dashboard URL:     %s\n", provisionResponse.DashboardURL)
+				fmt.Printf("  Access URL:     %s\n\n", provisionResponse.AccessURL)
+			}
+			return nil
+		})
 
 		// Then Provision again to test for idempotent provisions
 		if verbose {
 			color.Blue("\n\n→ POST %s (again to test idempotent provisions):\n", provisionUrl)
 			fmt.Printf("%s\n", requestJson)
 		}
-		provisionResponseTwo, err := marketplace.Provision(provisionUrl, request, cmd.Flag("basic-auth").Value.String())
-		if err != nil {
-			color.Red("%s", err)
-			os.Exit(1)
+		provisionedTwice := results.run("Provision #2 was successful", keepGoing, func() error {
+			var err error
+			provisionResponseTwo, err = client.Provision(ctx, provisionUrl, request)
+			if err != nil {
+				return err
+			}
+			if verbose {
+				fmt.Printf("\nSecond Provision was successful:\n")
+				fmt.Printf("  Status:     %s\n", provisionResponseTwo.Status)
+				fmt.Printf("  Dashboard URL:     %s\n", provisionResponseTwo.DashboardURL)
+				fmt.Printf("  Access URL:     %s\n\n", provisionResponseTwo.AccessURL)
+			}
+			return nil
+		})
+		if provisioned && provisionedTwice {
+			checkIdempotent(results, keepGoing, "Provision", provisionResponse, provisionResponseTwo)
 		}
-
-		if verbose {
-			fmt.Printf("\nSecond Provision was successful:\n")
-			fmt.Printf("  Status:     %s\n", provisionResponseTwo.Status)
-			fmt.Printf("  Dashboard URL:     %s\n", provisionResponseTwo.DashboardURL)
-			fmt.Printf("  Access URL:     %s\n\n", provisionResponseTwo.AccessURL)
+		if provisioned {
+			access.check(ctx, results, keepGoing, "provision", true)
 		}
 
-		color.Green("  ✓ Provision #2 was successful")
-
 		// Now, let's Update
-		updateUrl := baseUrl + "/update"
+		updateUrl := routes.url("update", quicknodeId, endpointId)
 		if verbose {
 			color.Blue("\n\n→ PUT %s:\n", updateUrl)
 		}
 
-	updateRequest := marketplace.UpdateRequest{
-		QuickNodeId:       cmd.Flag("quicknode-id").Value.String(),
-		EndpointId:        cmd.Flag("endpoint-id").Value.String(),
-		Chain:             cmd.Flag("chain").Value.String(),
-		Network:           cmd.Flag("network").Value.String(),
-		Plan:              cmd.Flag("plan").Value.String(),
-		WSSURL:            cmd.Flag("wss-url").Value.String(),
-		HTTPURL:           cmd.Flag("endpoint-url").Value.String(),
-		Referers:          []string{"https://quicknode.com"},
-		ContractAddresses: []string{"0x4d224452801ACEd8B2F0aebE155379bb5D594381"},
-		AddOnSlug:         cmd.Flag("add-on-slug").Value.String(),
-		AddOnId:           cmd.Flag("add-on-id").Value.String(),
-	}
+		updateRequest := marketplace.UpdateRequest{
+			QuickNodeId:       cmd.Flag("quicknode-id").Value.String(),
+			EndpointId:        cmd.Flag("endpoint-id").Value.String(),
+			Chain:             cmd.Flag("chain").Value.String(),
+			Network:           cmd.Flag("network").Value.String(),
+			Plan:              cmd.Flag("plan").Value.String(),
+			WSSURL:            cmd.Flag("wss-url").Value.String(),
+			HTTPURL:           cmd.Flag("endpoint-url").Value.String(),
+			Referers:          referers,
+			ContractAddresses: contractAddresses,
+			AddOnSlug:         cmd.Flag("add-on-slug").Value.String(),
+			AddOnId:           cmd.Flag("add-on-id").Value.String(),
+		}
 
 		// Check that it is protected by basic auth
-		updateIsProtectedByBasicAuth, err := marketplace.RequiresBasicAuth(updateUrl, "PUT")
-		if err != nil {
-			color.Red("%s", err)
-			os.Exit(1)
-		}
-		if !updateIsProtectedByBasicAuth {
-			color.Red("  ✘ The update API is not protected by basic auth.")
-			os.Exit(1)
-		} else {
-			color.Green("  ✓ Update API is protected by basic auth.")
-		}
+		results.run("Update API is protected by basic auth", keepGoing, func() error {
+			return checkBasicAuth(ctx, client, updateUrl, "PUT", "update")
+		})
 
 		updateRequestJson, _ := json.MarshalIndent(updateRequest, "", "  ")
 		if verbose {
 			fmt.Printf("%s\n", updateRequestJson)
 		}
 
-		updateResponse, err := marketplace.Update(updateUrl, updateRequest, cmd.Flag("basic-auth").Value.String())
-		if err != nil {
-			color.Red("%s", err)
-			os.Exit(1)
+		// QuickNode retries updates, deactivations and deprovisions, so each
+		// of them is sent twice and must return the same response both times
+		var updateResponses [2]marketplace.UpdateResponse
+		var updated [2]bool
+		for i := range updateResponses {
+			if i > 0 && verbose {
+				color.Blue("\n\n→ PUT %s (again to test idempotent updates):\n", updateUrl)
+				fmt.Printf("%s\n", updateRequestJson)
+			}
+			updated[i] = results.run(fmt.Sprintf("Update #%d was successful", i+1), keepGoing, func() error {
+				var err error
+				updateResponses[i], err = client.Update(ctx, updateUrl, updateRequest)
+				if err != nil {
+					return err
+				}
+				if verbose {
+					fmt.Printf("\nUpdate was successful:\n")
+					fmt.Printf("  Status:     %s\n\n", updateResponses[i].Status)
+				}
+				return nil
+			})
 		}
-		if verbose {
-			fmt.Printf("\nUpdate was successful:\n")
-			fmt.Printf("  Status:     %s\n\n", updateResponse.Status)
+		if updated[0] && updated[1] {
+			checkIdempotent(results, keepGoing, "Update", updateResponses[0], updateResponses[1])
+		}
+		if updated[0] {
+			access.check(ctx, results, keepGoing, "update", true)
 		}
-
-		color.Green("  ✓ Update was successful")
 
 		// Let's deactivate the endpoint
-		deactivateUrl := baseUrl + "/deactivate_endpoint"
+		deactivateUrl := routes.url("deactivate_endpoint", quicknodeId, endpointId)
 		if verbose {
 			color.Blue("\n\n→ DELETE %s:\n", deactivateUrl)
 		}
-	deactivateRequest := marketplace.DeactivateRequest{
-		QuickNodeId:  cmd.Flag("quicknode-id").Value.String(),
-		EndpointId:   cmd.Flag("endpoint-id").Value.String(),
-		Chain:        cmd.Flag("chain").Value.String(),
-		Network:      cmd.Flag("network").Value.String(),
-		DeactivateAt: time.Now().Unix(),
-		AddOnId:      cmd.Flag("add-on-id").Value.String(),
-		AddOnSlug:    cmd.Flag("add-on-slug").Value.String(),
-	}
+		deactivateRequest := marketplace.DeactivateRequest{
+			QuickNodeId:  cmd.Flag("quicknode-id").Value.String(),
+			EndpointId:   cmd.Flag("endpoint-id").Value.String(),
+			Chain:        cmd.Flag("chain").Value.String(),
+			Network:      cmd.Flag("network").Value.String(),
+			DeactivateAt: time.Now().Unix(),
+			AddOnId:      cmd.Flag("add-on-id").Value.String(),
+			AddOnSlug:    cmd.Flag("add-on-slug").Value.String(),
+		}
 
 		// Check that it is protected by basic auth
-		deactivateIsProtectedByBasicAuth, err := marketplace.RequiresBasicAuth(deactivateUrl, "DELETE")
-		if err != nil {
-			color.Red("%s", err)
-			os.Exit(1)
-		}
-		if !deactivateIsProtectedByBasicAuth {
-			color.Red("  ✘ The deactivate_endpoint API is not protected by basic auth.")
-			os.Exit(1)
-		} else {
-			color.Green("  ✓ Deactivate Endpoint API is protected by basic auth.")
-		}
+		results.run("Deactivate Endpoint API is protected by basic auth", keepGoing, func() error {
+			return checkBasicAuth(ctx, client, deactivateUrl, "DELETE", "deactivate_endpoint")
+		})
 
 		deactivateRequestJson, _ := json.MarshalIndent(deactivateRequest, "", "  ")
 		if verbose {
 			fmt.Printf("%s\n", deactivateRequestJson)
 		}
 
-		deactivateResponse, err := marketplace.Deactivate(deactivateUrl, deactivateRequest, cmd.Flag("basic-auth").Value.String())
-		if err != nil {
-			color.Red("%s", err)
-			os.Exit(1)
+		var deactivateResponses [2]marketplace.DeactivateResponse
+		var deactivated [2]bool
+		for i := range deactivateResponses {
+			if i > 0 && verbose {
+				color.Blue("\n\n→ DELETE %s (again to test idempotent deactivations):\n", deactivateUrl)
+				fmt.Printf("%s\n", deactivateRequestJson)
+			}
+			deactivated[i] = results.run(fmt.Sprintf("Deactivate Endpoint #%d was successful", i+1), keepGoing, func() error {
+				var err error
+				deactivateResponses[i], err = client.Deactivate(ctx, deactivateUrl, deactivateRequest)
+				if err != nil {
+					return err
+				}
+				if verbose {
+					fmt.Printf("\nDeactivate Endpoint was successful:\n")
+					fmt.Printf("  Status:     %s\n\n", deactivateResponses[i].Status)
+				}
+				return nil
+			})
 		}
-		if verbose {
-			fmt.Printf("\nDeactivate Endpoint was successful:\n")
-			fmt.Printf("  Status:     %s\n\n", deactivateResponse.Status)
+		if deactivated[0] && deactivated[1] {
+			checkIdempotent(results, keepGoing, "Deactivate Endpoint", deactivateResponses[0], deactivateResponses[1])
+		}
+		if deactivated[0] {
+			cleanup.deactivated(quicknodeId, endpointId)
+			access.check(ctx, results, keepGoing, "deactivate", false)
 		}
 
-		color.Green("  ✓ Deactivate Endpoint was successful")
-
-	// Finally, deprovision
-	deprovisionUrl := baseUrl + "/deprovision"
-	deprovisionRequest := marketplace.DeprovisionRequest{
-		QuickNodeId: cmd.Flag("quicknode-id").Value.String(),
-		AddOnId:     cmd.Flag("add-on-id").Value.String(),
-		AddOnSlug:   cmd.Flag("add-on-slug").Value.String(),
-	}
+		// Finally, deprovision. With --continue-on-failure this also cleans up
+		// after earlier failed steps.
+		deprovisionUrl := routes.url("deprovision", quicknodeId, endpointId)
+		deprovisionRequest := marketplace.DeprovisionRequest{
+			QuickNodeId: cmd.Flag("quicknode-id").Value.String(),
+			AddOnId:     cmd.Flag("add-on-id").Value.String(),
+			AddOnSlug:   cmd.Flag("add-on-slug").Value.String(),
+		}
 
 		// Check that it is protected by basic auth
-		deprovisionIsProtectedByBasicAuth, err := marketplace.RequiresBasicAuth(deprovisionUrl, "DELETE")
-		if err != nil {
-			color.Red("%s", err)
-			os.Exit(1)
-		}
-		if !deprovisionIsProtectedByBasicAuth {
-			color.Red("  ✘ The deprovision API is not protected by basic auth.")
-			os.Exit(1)
-		} else {
-			color.Green("  ✓ Deprovision API is protected by basic auth.")
-		}
+		results.run("Deprovision API is protected by basic auth", keepGoing, func() error {
+			return checkBasicAuth(ctx, client, deprovisionUrl, "DELETE", "deprovision")
+		})
 
 		if verbose {
 			color.Blue("\n\n→ DELETE %s:\n", deprovisionUrl)
@@ -237,17 +289,39 @@ The tool will use the base-url you pass to it and append these to the base URL t
 			fmt.Printf("%s\n", deprovisionRequestJson)
 		}
 
-		deprovisionResponse, err := marketplace.Deprovision(deprovisionUrl, deprovisionRequest, cmd.Flag("basic-auth").Value.String())
-		if err != nil {
-			color.Red("%s", err)
-			os.Exit(1)
+		var deprovisionResponses [2]marketplace.DeprovisionResponse
+		var deprovisioned [2]bool
+		for i := range deprovisionResponses {
+			if i > 0 && verbose {
+				color.Blue("\n\n→ DELETE %s (again to test idempotent deprovisions):\n", deprovisionUrl)
+				fmt.Printf("%s\n", deprovisionRequestJson)
+			}
+			deprovisioned[i] = results.run(fmt.Sprintf("Deprovision #%d was successful", i+1), keepGoing, func() error {
+				var err error
+				deprovisionResponses[i], err = client.Deprovision(ctx, deprovisionUrl, deprovisionRequest)
+				if err != nil {
+					return err
+				}
+				if verbose {
+					fmt.Printf("\nDeprovision was successful:\n")
+					fmt.Printf("\tStatus: \t\t%s\n\n", deprovisionResponses[i].Status)
+				}
+				return nil
+			})
 		}
-		if verbose {
-			fmt.Printf("\nDeprovision was successful:\n")
-			fmt.Printf("\tStatus: \t\t%s\n\n", deprovisionResponse.Status)
+		if deprovisioned[0] && deprovisioned[1] {
+			checkIdempotent(results, keepGoing, "Deprovision", deprovisionResponses[0], deprovisionResponses[1])
+		}
+		if deprovisioned[0] {
+			cleanup.deprovisioned(quicknodeId)
+			access.check(ctx, results, keepGoing, "deprovision", false)
 		}
 
-		color.Green("  ✓ Deprovision was successful")
+		cleanup.clean()
+		if keepGoing {
+			results.printSummary()
+		}
+		results.finish()
 	},
 }
 
@@ -268,4 +342,44 @@ func init() {
 	puddCmd.PersistentFlags().StringP("plan", "p", "discover", "The plan to provision the add-on for")
 	puddCmd.PersistentFlags().StringP("add-on-id", "i", "33", "The ID of the add-on to provision")
 	puddCmd.PersistentFlags().StringP("add-on-slug", "s", "myslug", "The slug of the add-on to provision")
+	addWhitelistFlags(puddCmd)
+
+	addRouteFlags(puddCmd)
+	addAccessFlags(puddCmd)
+
+	puddCmd.PersistentFlags().Bool("continue-on-failure", false, "Run every step even if an earlier one failed, then print a summary of all the results")
+
+	addMatrixFlags(puddCmd)
+	addCleanupFlags(puddCmd)
+}
+
+// checkIdempotent records whether a retried call to a provisioning action got
+// the same response as the first call, listing the fields that changed.
+func checkIdempotent(results *results, keepGoing bool, action string, first interface{}, second interface{}) {
+	results.run(fmt.Sprintf("%s #2 returned the same response as #1", action), keepGoing, func() error {
+		firstFields, secondFields := responseFields(first), responseFields(second)
+		var fields []string
+		for field := range firstFields {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		var changes []string
+		for _, field := range fields {
+			if firstFields[field] != secondFields[field] {
+				changes = append(changes, fmt.Sprintf("%s changed from %q to %q", field, firstFields[field], secondFields[field]))
+			}
+		}
+		if len(changes) > 0 {
+			return fmt.Errorf("the retried call returned different data: %s", strings.Join(changes, ", "))
+		}
+		return nil
+	})
+}
+
+// responseFields returns the JSON fields of a provisioning response, e.g. status and dashboard-url.
+func responseFields(response interface{}) map[string]string {
+	fields := map[string]string{}
+	data, _ := json.Marshal(response)
+	json.Unmarshal(data, &fields)
+	return fields
 }
@@ -0,0 +1,287 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCapturedBody is how much of each request and response body is kept in reports.
+const maxCapturedBody = 64 * 1024
+
+// exchange is a request made to the add-on and the response it got, as captured for reports.
+type exchange struct {
+	Method          string      `json:"method"`
+	URL             string      `json:"url"`
+	RequestHeader   http.Header `json:"request_headers,omitempty"`
+	RequestBody     string      `json:"request_body,omitempty"`
+	StatusCode      int         `json:"status_code,omitempty"`
+	ResponseHeader  http.Header `json:"response_headers,omitempty"`
+	ResponseBody    string      `json:"response_body,omitempty"`
+	Error           string      `json:"error,omitempty"`
+	DurationSeconds float64     `json:"duration_seconds"`
+}
+
+func (e exchange) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "→ %s %s\n", e.Method, e.URL)
+	if e.RequestBody != "" {
+		fmt.Fprintf(&b, "%s\n", strings.TrimSpace(e.RequestBody))
+	}
+	if e.Error != "" {
+		fmt.Fprintf(&b, "← %s\n", e.Error)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "← %d %s\n", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.ResponseBody != "" {
+		fmt.Fprintf(&b, "%s\n", strings.TrimSpace(e.ResponseBody))
+	}
+	return b.String()
+}
+
+// MarshalJSON embeds JSON request and response bodies as JSON rather than as strings.
+func (e exchange) MarshalJSON() ([]byte, error) {
+	type plain exchange
+	return json.Marshal(struct {
+		plain
+		RequestBody  interface{} `json:"request_body,omitempty"`
+		ResponseBody interface{} `json:"response_body,omitempty"`
+	}{plain(e), jsonBody(e.RequestBody), jsonBody(e.ResponseBody)})
+}
+
+// UnmarshalJSON reads back an exchange written by MarshalJSON, e.g. by a matrix run's child process.
+func (e *exchange) UnmarshalJSON(data []byte) error {
+	type plain exchange
+	var decoded struct {
+		plain
+		RequestBody  json.RawMessage `json:"request_body"`
+		ResponseBody json.RawMessage `json:"response_body"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*e = exchange(decoded.plain)
+	e.RequestBody = rawBody(decoded.RequestBody)
+	e.ResponseBody = rawBody(decoded.ResponseBody)
+	return nil
+}
+
+func rawBody(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+func jsonBody(body string) interface{} {
+	if body == "" {
+		return nil
+	}
+	if json.Valid([]byte(body)) {
+		return json.RawMessage(body)
+	}
+	return body
+}
+
+// recorder is a client middleware that captures every exchange with the add-on,
+// so that they can be attached to the check that made them.
+type recorder struct {
+	mu        sync.Mutex
+	exchanges []exchange
+}
+
+func (r *recorder) middleware(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		start := time.Now()
+		e := exchange{Method: req.Method, URL: req.URL.String(), RequestHeader: redactHeader(req.Header)}
+		if req.Body != nil {
+			body, err := ioutil.ReadAll(req.Body)
+			req.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+			e.RequestBody = capture(body)
+		}
+
+		res, err := next.RoundTrip(req)
+		e.DurationSeconds = time.Since(start).Seconds()
+		if err != nil {
+			e.Error = err.Error()
+			r.add(e)
+			return nil, err
+		}
+
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		e.StatusCode = res.StatusCode
+		e.ResponseHeader = res.Header
+		e.ResponseBody = capture(body)
+		if err != nil {
+			e.Error = err.Error()
+			r.add(e)
+			return nil, err
+		}
+		res.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.add(e)
+		return res, nil
+	})
+}
+
+func (r *recorder) add(e exchange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.exchanges = append(r.exchanges, e)
+}
+
+// take returns the exchanges captured since the last call.
+func (r *recorder) take() []exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	exchanges := r.exchanges
+	r.exchanges = nil
+	return exchanges
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// redactHeader hides credentials so that reports can be published as CI artifacts.
+func redactHeader(header http.Header) http.Header {
+	redacted := header.Clone()
+	if auth := redacted.Get("Authorization"); auth != "" {
+		scheme, _, _ := strings.Cut(auth, " ")
+		redacted.Set("Authorization", scheme+" [REDACTED]")
+	}
+	return redacted
+}
+
+func capture(body []byte) string {
+	if len(body) > maxCapturedBody {
+		return string(body[:maxCapturedBody]) + "..."
+	}
+	return string(body)
+}
+
+// parseReports parses the --report flags, e.g. junit=report.xml.
+func parseReports(values []string) (map[string]string, error) {
+	reports := map[string]string{}
+	for _, value := range values {
+		format, path, ok := strings.Cut(value, "=")
+		if !ok || path == "" {
+			return nil, fmt.Errorf("invalid --report %q, expected format=path (e.g. junit=report.xml)", value)
+		}
+		switch format {
+		case "junit":
+			reports[format] = path
+		default:
+			return nil, fmt.Errorf("unknown report format %q, expected junit", format)
+		}
+	}
+	return reports, nil
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut *junitOutput  `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",cdata"`
+}
+
+type junitOutput struct {
+	Text string `xml:",cdata"`
+}
+
+// writeJUnit writes the results as a JUnit XML report, with one testsuite per
+// group of checks and the captured requests and responses as each testcase's output.
+func writeJUnit(path string, r *results) error {
+	var suites junitTestSuites
+	var totals []time.Duration
+	index := map[string]int{}
+	for _, check := range r.Checks {
+		group := check.Group
+		if group == "" {
+			group = r.Suite
+		}
+		i, ok := index[group]
+		if !ok {
+			i = len(suites.Suites)
+			index[group] = i
+			suites.Suites = append(suites.Suites, junitTestSuite{Name: group, Timestamp: r.Started.Format(time.RFC3339)})
+			totals = append(totals, 0)
+		}
+		suite := &suites.Suites[i]
+		totals[i] += check.Duration
+
+		testCase := junitTestCase{
+			Name:      check.Name,
+			ClassName: "qn-marketplace-cli." + r.Suite,
+			Time:      junitSeconds(check.Duration),
+		}
+		if len(check.Exchanges) > 0 || check.Severity != "" {
+			var out strings.Builder
+			if check.Passed && check.Severity != "" {
+				fmt.Fprintf(&out, "WARNING [%s]: %s\n\n", check.Severity, check.Message)
+			}
+			for _, e := range check.Exchanges {
+				out.WriteString(e.String())
+				out.WriteString("\n")
+			}
+			testCase.SystemOut = &junitOutput{Text: out.String()}
+		}
+		if !check.Passed {
+			testCase.Failure = &junitFailure{Message: check.Message, Type: "failure", Text: check.Message}
+			suite.Failures++
+		}
+		suite.Tests++
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+	for i := range suites.Suites {
+		suites.Suites[i].Time = junitSeconds(totals[i])
+	}
+
+	data, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), 0644)
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
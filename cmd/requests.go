@@ -0,0 +1,66 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"time"
+
+	"github.com/quiknode-labs/qn-marketplace-cli/marketplace"
+	"github.com/spf13/cobra"
+)
+
+// provisionRequest builds a provision request for the given IDs and plan from the command's flags.
+func provisionRequest(cmd *cobra.Command, quicknodeId string, endpointId string, plan string) marketplace.ProvisionRequest {
+	referers, contractAddresses := whitelist(cmd)
+	return marketplace.ProvisionRequest{
+		QuickNodeId:       quicknodeId,
+		EndpointId:        endpointId,
+		Chain:             cmd.Flag("chain").Value.String(),
+		Network:           cmd.Flag("network").Value.String(),
+		Plan:              plan,
+		WSSURL:            cmd.Flag("wss-url").Value.String(),
+		HTTPURL:           cmd.Flag("endpoint-url").Value.String(),
+		Referers:          referers,
+		ContractAddresses: contractAddresses,
+		AddOnSlug:         cmd.Flag("add-on-slug").Value.String(),
+		AddOnId:           cmd.Flag("add-on-id").Value.String(),
+	}
+}
+
+func updateRequest(cmd *cobra.Command, quicknodeId string, endpointId string, plan string) marketplace.UpdateRequest {
+	referers, contractAddresses := whitelist(cmd)
+	return marketplace.UpdateRequest{
+		QuickNodeId:       quicknodeId,
+		EndpointId:        endpointId,
+		Chain:             cmd.Flag("chain").Value.String(),
+		Network:           cmd.Flag("network").Value.String(),
+		Plan:              plan,
+		WSSURL:            cmd.Flag("wss-url").Value.String(),
+		HTTPURL:           cmd.Flag("endpoint-url").Value.String(),
+		Referers:          referers,
+		ContractAddresses: contractAddresses,
+		AddOnSlug:         cmd.Flag("add-on-slug").Value.String(),
+		AddOnId:           cmd.Flag("add-on-id").Value.String(),
+	}
+}
+
+func deactivateRequest(cmd *cobra.Command, quicknodeId string, endpointId string) marketplace.DeactivateRequest {
+	return marketplace.DeactivateRequest{
+		QuickNodeId:  quicknodeId,
+		EndpointId:   endpointId,
+		Chain:        cmd.Flag("chain").Value.String(),
+		Network:      cmd.Flag("network").Value.String(),
+		DeactivateAt: time.Now().Unix(),
+		AddOnId:      cmd.Flag("add-on-id").Value.String(),
+		AddOnSlug:    cmd.Flag("add-on-slug").Value.String(),
+	}
+}
+
+func deprovisionRequest(cmd *cobra.Command, quicknodeId string) marketplace.DeprovisionRequest {
+	return marketplace.DeprovisionRequest{
+		QuickNodeId: quicknodeId,
+		AddOnId:     cmd.Flag("add-on-id").Value.String(),
+		AddOnSlug:   cmd.Flag("add-on-slug").Value.String(),
+	}
+}
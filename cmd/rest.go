@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"strings"
 
 	"github.com/fatih/color"
 	"github.com/quiknode-labs/qn-marketplace-cli/marketplace"
@@ -22,8 +21,11 @@ var restCmd = &cobra.Command{
 	Short: "Allows you to test your add-on's REST paths",
 	Args:  cobra.OnlyValidArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		header := color.New(color.FgWhite, color.BgBlue).SprintFunc()
-		fmt.Printf("%s\n\n", header("        REST        "))
+		if matrixRequested(cmd) {
+			runMatrix(cmd)
+			return
+		}
+		printHeader("REST")
 		verbose := cmd.Flag("verbose").Value.String() == "true"
 		provisionURL := cmd.Flag("url").Value.String()
 		if provisionURL == "" {
@@ -43,20 +45,27 @@ var restCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-	// First Provision
-	request := marketplace.ProvisionRequest{
-		QuickNodeId:       cmd.Flag("quicknode-id").Value.String(),
-		EndpointId:        cmd.Flag("endpoint-id").Value.String(),
-		Chain:             cmd.Flag("chain").Value.String(),
-		Network:           cmd.Flag("network").Value.String(),
-		Plan:              cmd.Flag("plan").Value.String(),
-		WSSURL:            cmd.Flag("wss-url").Value.String(),
-		HTTPURL:           cmd.Flag("endpoint-url").Value.String(),
-		Referers:          []string{"https://quicknode.com"},
-		ContractAddresses: []string{"0x4d224452801ACEd8B2F0aebE155379bb5D594381"},
-		AddOnSlug:         cmd.Flag("add-on-slug").Value.String(),
-		AddOnId:           cmd.Flag("add-on-id").Value.String(),
-	}
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		results := newResults(cmd, "rest")
+		client := newClient(cmd, results.recording())
+		cleanup := newRunCleanup(cmd, client, results, nil)
+
+		referers, contractAddresses := whitelist(cmd)
+		// First Provision
+		request := marketplace.ProvisionRequest{
+			QuickNodeId:       cmd.Flag("quicknode-id").Value.String(),
+			EndpointId:        cmd.Flag("endpoint-id").Value.String(),
+			Chain:             cmd.Flag("chain").Value.String(),
+			Network:           cmd.Flag("network").Value.String(),
+			Plan:              cmd.Flag("plan").Value.String(),
+			WSSURL:            cmd.Flag("wss-url").Value.String(),
+			HTTPURL:           cmd.Flag("endpoint-url").Value.String(),
+			Referers:          referers,
+			ContractAddresses: contractAddresses,
+			AddOnSlug:         cmd.Flag("add-on-slug").Value.String(),
+			AddOnId:           cmd.Flag("add-on-id").Value.String(),
+		}
 
 		if verbose {
 			color.Blue("→ POST %s:\n", provisionURL)
@@ -66,18 +75,22 @@ var restCmd = &cobra.Command{
 			fmt.Printf("%s\n", requestJson)
 		}
 
-		provisionResponse, err := marketplace.Provision(provisionURL, request, cmd.Flag("basic-auth").Value.String())
-		if err != nil {
-			color.Red("%s", err)
-			os.Exit(1)
-		}
-
-		if verbose {
-			fmt.Printf("\nProvision was successful:\n")
-			fmt.Printf("  Status:     %s\n", provisionResponse.Status)
-			fmt.Printf("  Dashboard URL:     %s\n", provisionResponse.DashboardURL)
-			fmt.Printf("  Access URL:     %s\n\n", provisionResponse.AccessURL)
-		}
+		cleanup.track(provisionURL, request)
+		results.run("Provision was successful", false, func() error {
+			provisionResponse, err := client.Provision(ctx, provisionURL, request)
+			if err != nil {
+				return err
+			}
+
+			if verbose {
+				fmt.Printf("\nProvision was successful:\n")
+				fmt.Printf("  Status:     %s\n", provisionResponse.Status)
+				fmt.Printf("  Dashboard URL:     %s\n", provisionResponse.DashboardURL)
+				fmt.Printf("  Access URL:     %s\n\n", provisionResponse.AccessURL)
+			}
+			recordProvisioned(cmd, provisionURL, request, provisionResponse)
+			return nil
+		})
 
 		// Now we can make the REST call
 		var requestBody = cmd.Flag("rest-body").Value.String()
@@ -88,46 +101,29 @@ var restCmd = &cobra.Command{
 			fmt.Printf("%s\n", requestBody)
 		}
 
-		httpReq, err := http.NewRequest(restVerb, cmd.Flag("rest-url").Value.String(), strings.NewReader(requestBody))
-		if err != nil {
-			color.Red("Error creating HTTP request: %s", err)
-			os.Exit(1)
-		}
-
-		// Set the HTTP request header to indicate that the request body is in JSON format
-		httpReq.Header.Set("Content-Type", "application/json")
-		httpReq.Header.Set("X-QUICKNODE-ID", cmd.Flag("quicknode-id").Value.String())
-		httpReq.Header.Set("X-INSTANCE-ID", cmd.Flag("endpoint-id").Value.String())
-		httpReq.Header.Set("X-QN-CHAIN", cmd.Flag("chain").Value.String())
-		httpReq.Header.Set("X-QN-NETWORK", cmd.Flag("network").Value.String())
-		httpReq.Header.Add("X-QN-TESTING", "true")
-
-		// Send the HTTP request and capture the response
-		client := http.Client{}
-		resp, err := client.Do(httpReq)
-		if err != nil {
-			color.Red("Error sending HTTP request: %s", err)
-			os.Exit(1)
-		}
-		defer resp.Body.Close()
-
-		// Decode the response body into an interface{} object
-		var respBody interface{}
-		err = json.NewDecoder(resp.Body).Decode(&respBody)
-		if err != nil {
-			color.Red("Error decoding JSON:", err)
-			os.Exit(1)
-		}
-
-		responseJson, _ := json.MarshalIndent(respBody, "", "  ")
-		if resp.StatusCode == 200 {
-			color.Green("  ✓ REST call was successful and returned:")
-			color.White("\n%s\n", responseJson)
-		} else {
-			color.Red("  ✘ REST call failed:     %s\n\n", resp.Status)
+		var responseJson []byte
+		passed := results.run("REST call was successful", false, func() error {
+			statusCode, respBody, callErr := client.REST(ctx, restVerb, cmd.Flag("rest-url").Value.String(), requestBody, marketplace.InstanceHeaders{
+				QuickNodeId: cmd.Flag("quicknode-id").Value.String(),
+				EndpointId:  cmd.Flag("endpoint-id").Value.String(),
+				Chain:       cmd.Flag("chain").Value.String(),
+				Network:     cmd.Flag("network").Value.String(),
+			})
+			if callErr != nil {
+				return callErr
+			}
+
+			responseJson, _ = json.MarshalIndent(respBody, "", "  ")
+			if statusCode != http.StatusOK {
+				return fmt.Errorf("the add-on responded with %d %s:\n%s", statusCode, http.StatusText(statusCode), responseJson)
+			}
+			return nil
+		})
+		if passed {
 			color.White("\n%s\n", responseJson)
-			os.Exit(1)
 		}
+
+		results.finish()
 	},
 }
 
@@ -148,8 +144,13 @@ func init() {
 	restCmd.PersistentFlags().StringP("plan", "p", "discover", "The plan to provision the add-on for")
 	restCmd.PersistentFlags().StringP("add-on-id", "i", "33", "The ID of the add-on to provision")
 	restCmd.PersistentFlags().StringP("add-on-slug", "s", "myslug", "The slug of the add-on to provision")
+	addWhitelistFlags(restCmd)
+	addInstanceNameFlag(restCmd)
+	addCleanupFlags(restCmd)
 
 	restCmd.PersistentFlags().String("rest-url", "", "The URL to make the REST calls to")
 	restCmd.PersistentFlags().String("rest-verb", "", "The REST HTTP Method or verb to use (e.g. GET or POST)")
 	restCmd.PersistentFlags().String("rest-body", "", "The Rest Request Body")
+
+	addMatrixFlags(restCmd)
 }
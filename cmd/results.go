@@ -0,0 +1,209 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/quiknode-labs/qn-marketplace-cli/marketplace"
+	"github.com/spf13/cobra"
+)
+
+// checkResult is the outcome of one check made by a command, e.g.
+// "Provision API is protected by basic auth".
+type checkResult struct {
+	Group     string
+	Name      string
+	Passed    bool
+	Message   string
+	Severity  string
+	Duration  time.Duration
+	Exchanges []exchange
+}
+
+// results collects the outcome of every check made during a command's run,
+// along with the requests and responses each check made.
+type results struct {
+	Suite   string
+	Started time.Time
+	Checks  []checkResult
+
+	group    string
+	recorder *recorder
+	reports  map[string]string
+	cleanups []func()
+	// outputErr is the first error writing the checks to stdout, e.g. to a closed pipe
+	outputErr error
+}
+
+// newResults starts collecting results for a command, exiting if its --report flags are invalid.
+func newResults(cmd *cobra.Command, suite string) *results {
+	values, _ := cmd.Flags().GetStringArray("report")
+	reports, err := parseReports(values)
+	if err != nil {
+		color.Red("%s", err)
+		os.Exit(1)
+	}
+	return &results{Suite: suite, Started: time.Now(), recorder: &recorder{}, reports: reports}
+}
+
+// recording returns the client option that captures requests and responses for the checks.
+func (r *results) recording() marketplace.ClientOption {
+	return marketplace.WithMiddleware(r.recorder.middleware)
+}
+
+// setGroup groups the checks that follow, e.g. by scenario file.
+func (r *results) setGroup(group string) {
+	r.group = group
+}
+
+// pass records and prints a successful check that started at start.
+func (r *results) pass(name string, start time.Time) {
+	r.add(checkResult{Group: r.group, Name: name, Passed: true, Duration: time.Since(start), Exchanges: r.recorder.take()})
+	if outputFormat == outputText {
+		color.Green("  ✓ %s", name)
+	}
+}
+
+// fail records and prints a failed check that started at start.
+func (r *results) fail(name string, start time.Time, err error) {
+	r.add(checkResult{Group: r.group, Name: name, Message: err.Error(), Duration: time.Since(start), Exchanges: r.recorder.take()})
+	if outputFormat == outputText {
+		color.Red("  ✘ %s: %s", name, err)
+	}
+}
+
+// finding records and prints a check that found a weakness of the given
+// severity, e.g. a missing security header: a failure if failed is set, and
+// a check that passed with a warning otherwise.
+func (r *results) finding(name string, start time.Time, failed bool, severity string, message string) {
+	r.add(checkResult{Group: r.group, Name: name, Passed: !failed, Message: message, Severity: severity, Duration: time.Since(start), Exchanges: r.recorder.take()})
+	if outputFormat != outputText {
+		return
+	}
+	if failed {
+		color.Red("  ✘ %s: [%s] %s", name, severity, message)
+	} else {
+		color.Yellow("  ! %s: [%s] %s", name, severity, message)
+	}
+}
+
+func (r *results) add(check checkResult) {
+	r.Checks = append(r.Checks, check)
+	if err := emitCheck(r.Suite, check); err != nil && r.outputErr == nil {
+		r.outputErr = err
+	}
+}
+
+// run runs check and records its outcome under name. Unless keepGoing is set,
+// the command finishes as soon as a check fails.
+func (r *results) run(name string, keepGoing bool, check func() error) bool {
+	// Requests made outside of a check are not attributed to the next one
+	r.recorder.take()
+
+	start := time.Now()
+	if err := check(); err != nil {
+		r.fail(name, start, err)
+		if !keepGoing {
+			r.finish()
+		}
+		return false
+	}
+	r.pass(name, start)
+	return true
+}
+
+// onFinish registers a function to run when the command finishes, before the
+// results are reported, e.g. to clean up what the command provisioned.
+func (r *results) onFinish(cleanup func()) {
+	r.cleanups = append(r.cleanups, cleanup)
+}
+
+func (r *results) failed() bool {
+	for _, check := range r.Checks {
+		if !check.Passed {
+			return true
+		}
+	}
+	return false
+}
+
+// finish writes the reports requested with --report and the --output records,
+// and exits with a non-zero code if any check failed.
+func (r *results) finish() {
+	cleanups := r.cleanups
+	r.cleanups = nil
+	for _, cleanup := range cleanups {
+		cleanup()
+	}
+
+	if err := emitResults(r); err != nil && r.outputErr == nil {
+		r.outputErr = err
+	}
+	if r.outputErr != nil {
+		color.Red("Could not write the results: %s", r.outputErr)
+		os.Exit(1)
+	}
+
+	for format, path := range r.reports {
+		var err error
+		switch format {
+		case "junit":
+			err = writeJUnit(path, r)
+		}
+		if err != nil {
+			color.Red("Could not write the %s report to %s: %s", format, path, err)
+			os.Exit(1)
+		}
+	}
+
+	if r.failed() {
+		os.Exit(1)
+	}
+}
+
+// printSummary prints a table with the outcome of every check.
+func (r *results) printSummary() {
+	if outputFormat != outputText {
+		return
+	}
+	fmt.Println()
+	printHeader("RESULTS")
+
+	passed, failed, warnings := 0, 0, 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	for _, check := range r.Checks {
+		label := color.GreenString("PASS")
+		if check.Passed && check.Severity != "" {
+			label = color.YellowString("WARN")
+			passed++
+			warnings++
+		} else if check.Passed {
+			passed++
+		} else {
+			label = color.RedString("FAIL")
+			failed++
+		}
+		name := check.Name
+		if check.Group != "" {
+			name = check.Group + ": " + name
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", label, name, check.Duration.Round(time.Millisecond))
+	}
+	w.Flush()
+
+	summary := fmt.Sprintf("\n  %d passed, %d failed", passed, failed)
+	if warnings > 0 {
+		summary += fmt.Sprintf(", %d with warnings", warnings)
+	}
+	if failed > 0 {
+		color.Red(summary)
+	} else {
+		color.Green(summary)
+	}
+}
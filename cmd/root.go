@@ -5,8 +5,12 @@ Copyright © 2023 QuickNode, Inc.
 package cmd
 
 import (
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/quiknode-labs/qn-marketplace-cli/marketplace"
 	"github.com/spf13/cobra"
 )
 
@@ -23,12 +27,29 @@ Among other things, it provides:
   - A command to test your an add-on's RPC methods
 	
 For more information, visit https://www.quicknode.com/marketplace`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyConfig(cmd); err != nil {
+			// The usage doesn't help with mistakes in the config file or environment
+			cmd.SilenceUsage = true
+			return err
+		}
+		return setupOutput(cmd)
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	err := rootCmd.Execute()
+	// Ctrl-C cancels the running command's context so that it can report its
+	// results and clean up, a second Ctrl-C exits right away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	err := rootCmd.ExecuteContext(ctx)
 	if err != nil {
 		os.Exit(1)
 	}
@@ -36,4 +57,12 @@ func Execute() {
 
 func init() {
 	rootCmd.PersistentFlags().Bool("verbose", false, "Verbose output")
+	rootCmd.PersistentFlags().Duration("timeout", marketplace.DefaultTimeout, "The timeout for each HTTP request made to the add-on (0 to disable)")
+	rootCmd.PersistentFlags().StringP("output", "o", outputText, "The output format: text, json (one document at the end) or ndjson (one record per check as they complete)")
+	rootCmd.PersistentFlags().StringArray("report", []string{}, "Write a report of every check, as format=path, e.g. junit=report.xml (can be repeated)")
+	rootCmd.PersistentFlags().Duration("deadline", 0, "The overall deadline for the whole command, e.g. 2m (0 to disable)")
+	rootCmd.PersistentFlags().String("config", "", "The project config file to read flags from (defaults to the closest "+configFileName+" from the working directory up)")
+	rootCmd.PersistentFlags().String("profile", "", "The profile of the config file to use (defaults to its default-profile)")
+	rootCmd.PersistentFlags().Bool("no-config", false, "Ignore the config file and the QN_* environment variables")
+	rootCmd.PersistentFlags().String("state-file", "", "The file recording the instances provisioned by the CLI (defaults to qn-marketplace-cli/instances.json in the user config directory)")
 }
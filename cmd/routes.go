@@ -0,0 +1,90 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/quiknode-labs/qn-marketplace-cli/marketplace"
+	"github.com/spf13/cobra"
+)
+
+// routeActions are the provisioning actions, with the flag overriding each one's URL.
+var routeActions = []struct {
+	Action string
+	Flag   string
+}{
+	{"provision", "provision-url"},
+	{"update", "update-url"},
+	{"deactivate_endpoint", "deactivate-url"},
+	{"deprovision", "deprovision-url"},
+}
+
+var routePlaceholder = regexp.MustCompile(`\{([a-z_-]+)\}`)
+
+// routePlaceholders are the values that can be used in route templates.
+var routePlaceholders = map[string]bool{"action": true, "quicknode-id": true, "endpoint-id": true, "chain": true, "network": true}
+
+// routes builds the URLs of the provisioning actions from --base-url and the
+// --route-template and --<action>-url flags.
+type routes struct {
+	client    *marketplace.Client
+	templates map[string]string
+	chain     string
+	network   string
+}
+
+// addRouteFlags adds the flags that map the provisioning actions to the add-on's routes.
+func addRouteFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("route-template", "/{action}", "The path of every action relative to the base URL, with {action}, {quicknode-id}, {endpoint-id}, {chain} and {network} placeholders")
+	for _, route := range routeActions {
+		cmd.PersistentFlags().String(route.Flag, "", fmt.Sprintf("The URL or path (relative to the base URL) of the %s action, overriding --route-template", route.Action))
+	}
+}
+
+// newRoutes returns the routes for the command's flags, checking their placeholders.
+func newRoutes(cmd *cobra.Command, client *marketplace.Client) (*routes, error) {
+	r := &routes{client: client, templates: map[string]string{}}
+	// The cleanup command has no chain and network, they come from each instance
+	if f := cmd.Flag("chain"); f != nil {
+		r.chain = f.Value.String()
+	}
+	if f := cmd.Flag("network"); f != nil {
+		r.network = f.Value.String()
+	}
+	for _, route := range routeActions {
+		template := cmd.Flag(route.Flag).Value.String()
+		flag := route.Flag
+		if template == "" {
+			template = cmd.Flag("route-template").Value.String()
+			flag = "route-template"
+		}
+		for _, match := range routePlaceholder.FindAllStringSubmatch(template, -1) {
+			if !routePlaceholders[match[1]] {
+				return nil, fmt.Errorf("unknown placeholder %s in --%s %q, expected {action}, {quicknode-id}, {endpoint-id}, {chain} or {network}", match[0], flag, template)
+			}
+		}
+		r.templates[route.Action] = template
+	}
+	return r, nil
+}
+
+// url returns the URL of an action for an account and endpoint. Actions are
+// provision, update, deactivate_endpoint and deprovision.
+func (r *routes) url(action string, quicknodeId string, endpointId string) string {
+	values := map[string]string{
+		"action":       action,
+		"quicknode-id": quicknodeId,
+		"endpoint-id":  endpointId,
+		"chain":        r.chain,
+		"network":      r.network,
+	}
+	path := routePlaceholder.ReplaceAllStringFunc(r.templates[action], func(placeholder string) string {
+		return url.PathEscape(values[strings.Trim(placeholder, "{}")])
+	})
+	return r.client.URL(path)
+}
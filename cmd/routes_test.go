@@ -0,0 +1,85 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/quiknode-labs/qn-marketplace-cli/marketplace"
+	"github.com/spf13/cobra"
+)
+
+func TestRoutes(t *testing.T) {
+	tests := []struct {
+		name  string
+		base  string
+		flags map[string]string
+		// want maps actions to their URL for the account abc and endpoint a/b c
+		want map[string]string
+		err  string
+	}{
+		{
+			name: "default template",
+			base: "http://localhost:3000/provisioning/",
+			want: map[string]string{
+				"provision":           "http://localhost:3000/provisioning/provision",
+				"deactivate_endpoint": "http://localhost:3000/provisioning/deactivate_endpoint",
+			},
+		},
+		{
+			name:  "placeholders are escaped",
+			base:  "http://localhost:3000",
+			flags: map[string]string{"route-template": "/{chain}/{network}/accounts/{quicknode-id}/endpoints/{endpoint-id}/{action}"},
+			want: map[string]string{
+				"update": "http://localhost:3000/solana/devnet/accounts/abc/endpoints/a%2Fb%20c/update",
+			},
+		},
+		{
+			name:  "action URLs override the template",
+			base:  "http://localhost:3000/api?key=1",
+			flags: map[string]string{"route-template": "/v1/{action}", "deprovision-url": "/accounts/{quicknode-id}", "update-url": "https://other.example.com/update"},
+			want: map[string]string{
+				"provision":   "http://localhost:3000/api/v1/provision?key=1",
+				"update":      "https://other.example.com/update",
+				"deprovision": "http://localhost:3000/api/accounts/abc?key=1",
+			},
+		},
+		{
+			name:  "unknown placeholder",
+			base:  "http://localhost:3000",
+			flags: map[string]string{"provision-url": "/{account}/provision"},
+			err:   "unknown placeholder {account} in --provision-url",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			cmd.Flags().String("chain", "solana", "")
+			cmd.Flags().String("network", "devnet", "")
+			addRouteFlags(cmd)
+			for name, value := range test.flags {
+				if err := cmd.PersistentFlags().Set(name, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r, err := newRoutes(cmd, marketplace.NewClient(marketplace.WithBaseURL(test.base)))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for action, want := range test.want {
+				if got := r.url(action, "abc", "a/b c"); got != want {
+					t.Errorf("%s: got %q, want %q", action, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,550 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/quiknode-labs/qn-marketplace-cli/marketplace"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/cobra"
+)
+
+// scenarioCmd represents the scenario command
+var scenarioCmd = &cobra.Command{
+	Use:   "scenario",
+	Short: "Allows you to test your add-on against declarative YAML scenario files",
+}
+
+// scenarioRunCmd represents the scenario run command
+var scenarioRunCmd = &cobra.Command{
+	Use:   "run [scenario.yaml...]",
+	Short: "Runs one or more YAML scenario files against your add-on",
+	Long: `Use this command to run arbitrary sequences of calls to your add-on, described in YAML files
+that can be versioned alongside your add-on's code.
+
+A scenario looks like this:
+
+  name: provision twice then deprovision
+  base-url: http://localhost:3030/provisioning
+  vars:
+    second_endpoint: "{{ uuid }}"
+  instance:
+    basic-auth: dXNlcm5hbWU6cGFzc3dvcmQ=
+    chain: ethereum
+    network: mainnet
+    plan: discover
+  steps:
+    - action: provision
+      url: /provision
+      expect:
+        status: 200
+        body:
+          status: success
+      save:
+        dashboard: dashboard-url
+    - name: provision a second endpoint on another plan
+      action: provision
+      url: /provision
+      payload:
+        endpoint-id: "{{ .second_endpoint }}"
+        plan: pro
+    - action: rpc
+      url: http://localhost:3030/rpc
+      method: qn_myMethod
+      params: [1, "abc"]
+      expect:
+        body:
+          result.count: 1
+    - action: deprovision
+      url: /deprovision
+
+Actions are provision, update, deactivate, deprovision, rpc, rest, sso and healthcheck.
+The instance (and each step's) can also set the referers and contract-addresses lists, including empty ones.
+Contract addresses must match the chain's address format unless allow-invalid-contract-addresses is true.
+An sso step signs its token with its sso settings: jwt-secret, name, email and org, and like the sso
+command's flags jwt-algorithm, jwt-private-key, jwt-ttl, jwt-claims and jwt-claim-names (a mapping of
+claims to their new names).
+Strings are Go templates: vars, values saved by earlier steps (plus quicknode_id, endpoint_id,
+dashboard_url and access_url) are available as {{ .name }}, along with the uuid, now and env functions.
+Steps expect a 200 unless told otherwise, and a scenario stops at its first failing step.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		printHeader("SCENARIO")
+		verbose := cmd.Flag("verbose").Value.String() == "true"
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+
+		overrides := map[string]string{}
+		varFlags, _ := cmd.Flags().GetStringArray("var")
+		for _, v := range varFlags {
+			key, value, ok := strings.Cut(v, "=")
+			if !ok {
+				color.Red("Invalid --var %q, expected key=value", v)
+				os.Exit(1)
+			}
+			overrides[key] = value
+		}
+
+		results := newResults(cmd, "scenario")
+		for _, path := range args {
+			scenario, err := loadScenarioFile(path)
+			if err != nil {
+				color.Red("%s", err)
+				os.Exit(1)
+			}
+
+			run := &scenarioRun{cmd: cmd, scenario: scenario, results: results, verbose: verbose}
+			if err := run.init(overrides); err != nil {
+				color.Red("%s: %s", path, err)
+				os.Exit(1)
+			}
+			run.run(ctx)
+		}
+
+		results.finish()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(scenarioCmd)
+	scenarioCmd.AddCommand(scenarioRunCmd)
+
+	scenarioRunCmd.PersistentFlags().StringArray("var", []string{}, "Set a scenario variable, as key=value (can be repeated)")
+}
+
+// scenarioRun holds the state shared by the steps of one scenario.
+type scenarioRun struct {
+	cmd      *cobra.Command
+	scenario *scenarioFile
+	instance scenarioInstance
+	vars     map[string]string
+	results  *results
+	verbose  bool
+}
+
+// scenarioResponse is what a step got back from the add-on.
+type scenarioResponse struct {
+	statusCode int
+	body       []byte
+	decoded    interface{}
+}
+
+func (r *scenarioRun) init(overrides map[string]string) error {
+	r.vars = map[string]string{}
+	for key, value := range r.scenario.Vars {
+		expanded, err := expandTemplate(value, nil)
+		if err != nil {
+			return fmt.Errorf("var %s: %w", key, err)
+		}
+		r.vars[key] = expanded
+	}
+	for key, value := range overrides {
+		r.vars[key] = value
+	}
+
+	defaults := scenarioInstance{
+		BasicAuth:   "QWxhZGRpbjpvcGVuIHNlc2FtZQ==",
+		QuickNodeId: uuid.NewV4().String(),
+		EndpointId:  uuid.NewV4().String(),
+		EndpointURL: "https://long-late-firefly.quiknode.pro/4bb1e6b2dec8294938b6fdfdb7cf0cf70c4e97a2/",
+		WSSURL:      "wss://long-late-firefly.quiknode.pro/4bb1e6b2dec8294938b6fdfdb7cf0cf70c4e97a2/",
+		Chain:       defaultScenarioChain,
+		Network:     "mainnet",
+		Plan:        "discover",
+		AddOnId:     "33",
+		AddOnSlug:   "myslug",
+	}
+	instance, err := defaults.merge(r.scenario.Instance).expand(r.vars)
+	if err != nil {
+		return fmt.Errorf("instance: %w", err)
+	}
+	r.instance = instance
+	if _, ok := r.vars["quicknode_id"]; !ok {
+		r.vars["quicknode_id"] = instance.QuickNodeId
+	}
+	if _, ok := r.vars["endpoint_id"]; !ok {
+		r.vars["endpoint_id"] = instance.EndpointId
+	}
+
+	return nil
+}
+
+// run runs the steps of the scenario until one of them fails.
+func (r *scenarioRun) run(ctx context.Context) {
+	color.Blue("%s\n", r.scenario.Name)
+	r.results.setGroup(r.scenario.Name)
+
+	for _, step := range r.scenario.Steps {
+		passed := r.results.run(step.Name, true, func() error {
+			return r.runStep(ctx, step)
+		})
+		if !passed {
+			break
+		}
+	}
+
+	fmt.Println()
+}
+
+func (r *scenarioRun) runStep(ctx context.Context, step scenarioStep) error {
+	instance, err := r.instance.merge(step.Instance).expand(r.vars)
+	if err != nil {
+		return err
+	}
+	url, err := expandTemplate(step.URL, r.vars)
+	if err != nil {
+		return err
+	}
+	baseURL, err := expandTemplate(r.scenario.BaseURL, r.vars)
+	if err != nil {
+		return err
+	}
+	client := newClient(r.cmd, marketplace.WithBaseURL(baseURL), marketplace.WithBasicAuth(instance.BasicAuth), r.results.recording())
+
+	response, err := r.send(ctx, client, step, instance, url)
+	if err != nil {
+		return err
+	}
+
+	if r.verbose {
+		fmt.Printf("\n  Status Code: %d\n  Response Body:\n%s\n\n", response.statusCode, response.body)
+	}
+
+	if err := r.check(step.Expect, response); err != nil {
+		return err
+	}
+
+	if step.Action == "provision" {
+		if dashboardURL, ok := lookupPath(response.decoded, "dashboard-url"); ok {
+			r.vars["dashboard_url"] = fmt.Sprint(dashboardURL)
+		}
+		if accessURL, ok := lookupPath(response.decoded, "access-url"); ok {
+			r.vars["access_url"] = fmt.Sprint(accessURL)
+		}
+	}
+	for name, path := range step.Save {
+		value, ok := lookupPath(response.decoded, path)
+		if !ok {
+			return fmt.Errorf("cannot save %s: the response has no %s", name, path)
+		}
+		if s, isString := value.(string); isString {
+			r.vars[name] = s
+		} else {
+			encoded, _ := json.Marshal(value)
+			r.vars[name] = string(encoded)
+		}
+	}
+
+	return nil
+}
+
+// send makes the call described by the step and returns the add-on's response.
+func (r *scenarioRun) send(ctx context.Context, client *marketplace.Client, step scenarioStep, instance scenarioInstance, url string) (*scenarioResponse, error) {
+	if url == "" && step.Action != "sso" {
+		return nil, fmt.Errorf("the step has no url")
+	}
+
+	switch step.Action {
+	case "provision", "update", "deactivate", "deprovision":
+		if step.Action == "provision" || step.Action == "update" {
+			if err := instance.checkContractAddresses(); err != nil {
+				return nil, err
+			}
+		}
+		method, request := scenarioRequest(step.Action, instance)
+		payload, err := r.payload(request, step.Payload)
+		if err != nil {
+			return nil, err
+		}
+		r.logRequest(method, client.URL(url), payload)
+		res, err := client.Send(ctx, method, url, payload)
+		if err != nil {
+			return nil, err
+		}
+		return newScenarioResponse(res.StatusCode, res.Body), nil
+
+	case "rpc":
+		params, err := expandValue(step.Params, r.vars)
+		if err != nil {
+			return nil, err
+		}
+		request := marketplace.RPCRequest{Method: step.Method, Params: []interface{}{}, ID: uuid.NewV4().String()}
+		if p, ok := params.([]interface{}); ok && p != nil {
+			request.Params = p
+		}
+		r.logRequest("POST", client.URL(url), request)
+		statusCode, decoded, err := client.RPC(ctx, url, request, instanceHeaders(instance))
+		return scenarioCallResponse(statusCode, decoded, err)
+
+	case "rest":
+		body, err := expandTemplate(step.Body, r.vars)
+		if err != nil {
+			return nil, err
+		}
+		verb := step.Verb
+		if verb == "" {
+			verb = "GET"
+		}
+		r.logRequest(verb, client.URL(url), body)
+		statusCode, decoded, err := client.REST(ctx, verb, url, body, instanceHeaders(instance))
+		return scenarioCallResponse(statusCode, decoded, err)
+
+	case "sso":
+		if url == "" {
+			url = r.vars["dashboard_url"]
+		}
+		if url == "" {
+			return nil, fmt.Errorf("the step has no url and no dashboard-url was returned by a previous provision")
+		}
+		sso, err := expandValue(map[string]interface{}{
+			"secret":      step.SSO.JWTSecret,
+			"algorithm":   step.SSO.JWTAlgorithm,
+			"private-key": step.SSO.JWTPrivateKey,
+			"claims":      step.SSO.JWTClaims,
+			"name":        step.SSO.Name,
+			"email":       step.SSO.Email,
+			"org":         step.SSO.Org,
+		}, r.vars)
+		if err != nil {
+			return nil, err
+		}
+		fields := sso.(map[string]interface{})
+		settings := jwtSettings{
+			Algorithm:  fields["algorithm"].(string),
+			PrivateKey: fields["private-key"].(string),
+			TTL:        step.SSO.JWTTTL,
+			Claims:     fields["claims"].(string),
+		}
+		if settings.Algorithm == "" {
+			settings.Algorithm = "HS256"
+		}
+		if settings.TTL == 0 {
+			settings.TTL = marketplace.DefaultJWTTTL
+		}
+		for claim, name := range step.SSO.JWTClaimNames {
+			settings.ClaimNames = append(settings.ClaimNames, claim+"="+name)
+		}
+		options, err := settings.options()
+		if err != nil {
+			return nil, err
+		}
+		token, err := marketplace.GetJWT(fields["secret"].(string), marketplace.User{
+			QuicknodeID:      instance.QuickNodeId,
+			Name:             fields["name"].(string),
+			Email:            fields["email"].(string),
+			OrganizationName: fields["org"].(string),
+		}, options...)
+		if err != nil {
+			return nil, fmt.Errorf("could not generate JWT: %w", err)
+		}
+		dashboardURL, err := withToken(client.URL(url), token)
+		if err != nil {
+			return nil, err
+		}
+		r.logRequest("GET", dashboardURL, nil)
+		statusCode, body, err := client.OpenDashboard(ctx, dashboardURL)
+		return scenarioPageResponse(statusCode, body, err)
+
+	case "healthcheck":
+		r.logRequest("GET", client.URL(url), nil)
+		statusCode, body, err := client.Healthcheck(ctx, url)
+		return scenarioPageResponse(statusCode, body, err)
+	}
+
+	return nil, fmt.Errorf("unknown action %q", step.Action)
+}
+
+// payload applies the step's payload overrides on top of the generated request.
+func (r *scenarioRun) payload(request interface{}, overrides map[string]interface{}) (interface{}, error) {
+	if len(overrides) == 0 {
+		return request, nil
+	}
+	expanded, err := expandValue(overrides, r.vars)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(encoded, &payload); err != nil {
+		return nil, err
+	}
+	for key, value := range expanded.(map[string]interface{}) {
+		payload[key] = value
+	}
+	return payload, nil
+}
+
+func (r *scenarioRun) logRequest(method string, url string, body interface{}) {
+	if !r.verbose {
+		return
+	}
+	color.Blue("\n→ %s %s:\n", method, url)
+	switch b := body.(type) {
+	case nil:
+	case string:
+		fmt.Printf("%s\n", b)
+	default:
+		requestJson, _ := json.MarshalIndent(b, "", "  ")
+		fmt.Printf("%s\n", requestJson)
+	}
+}
+
+// check verifies the step's expectations against the response.
+func (r *scenarioRun) check(expect scenarioExpect, response *scenarioResponse) error {
+	status := expect.Status
+	if len(status) == 0 {
+		status = statusCodes{200}
+	}
+	if !status.contains(response.statusCode) {
+		return fmt.Errorf("expected status code %v, got %d: %s", []int(status), response.statusCode, strings.TrimSpace(string(response.body)))
+	}
+
+	if expect.Contains != "" {
+		contains, err := expandTemplate(expect.Contains, r.vars)
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(string(response.body), contains) {
+			return fmt.Errorf("expected the response to contain %q", contains)
+		}
+	}
+
+	for path, want := range expect.Body {
+		want, err := expandValue(want, r.vars)
+		if err != nil {
+			return err
+		}
+		got, ok := lookupPath(response.decoded, path)
+		if !ok {
+			return fmt.Errorf("expected the response to have %s", path)
+		}
+		if !jsonEqual(got, want) {
+			return fmt.Errorf("expected %s to be %v, got %v", path, want, got)
+		}
+	}
+
+	return nil
+}
+
+// scenarioRequest builds the request QuickNode would send for a provisioning action.
+func scenarioRequest(action string, instance scenarioInstance) (string, interface{}) {
+	referers, contractAddresses := instance.whitelist()
+	switch action {
+	case "provision":
+		return "POST", marketplace.ProvisionRequest{
+			QuickNodeId:       instance.QuickNodeId,
+			EndpointId:        instance.EndpointId,
+			Chain:             instance.Chain,
+			Network:           instance.Network,
+			Plan:              instance.Plan,
+			WSSURL:            instance.WSSURL,
+			HTTPURL:           instance.EndpointURL,
+			Referers:          referers,
+			ContractAddresses: contractAddresses,
+			AddOnSlug:         instance.AddOnSlug,
+			AddOnId:           instance.AddOnId,
+		}
+	case "update":
+		return "PUT", marketplace.UpdateRequest{
+			QuickNodeId:       instance.QuickNodeId,
+			EndpointId:        instance.EndpointId,
+			Chain:             instance.Chain,
+			Network:           instance.Network,
+			Plan:              instance.Plan,
+			WSSURL:            instance.WSSURL,
+			HTTPURL:           instance.EndpointURL,
+			Referers:          referers,
+			ContractAddresses: contractAddresses,
+			AddOnSlug:         instance.AddOnSlug,
+			AddOnId:           instance.AddOnId,
+		}
+	case "deactivate":
+		return "DELETE", marketplace.DeactivateRequest{
+			QuickNodeId:  instance.QuickNodeId,
+			EndpointId:   instance.EndpointId,
+			Chain:        instance.Chain,
+			Network:      instance.Network,
+			DeactivateAt: time.Now().Unix(),
+			AddOnId:      instance.AddOnId,
+			AddOnSlug:    instance.AddOnSlug,
+		}
+	default:
+		return "DELETE", marketplace.DeprovisionRequest{
+			QuickNodeId: instance.QuickNodeId,
+			AddOnId:     instance.AddOnId,
+			AddOnSlug:   instance.AddOnSlug,
+		}
+	}
+}
+
+func instanceHeaders(instance scenarioInstance) marketplace.InstanceHeaders {
+	return marketplace.InstanceHeaders{
+		QuickNodeId: instance.QuickNodeId,
+		EndpointId:  instance.EndpointId,
+		Chain:       instance.Chain,
+		Network:     instance.Network,
+	}
+}
+
+func newScenarioResponse(statusCode int, body []byte) *scenarioResponse {
+	response := &scenarioResponse{statusCode: statusCode, body: body}
+	// Not every response is JSON, in which case only the status and raw body can be checked
+	json.Unmarshal(body, &response.decoded)
+	return response
+}
+
+// scenarioCallResponse converts the result of an RPC or REST call, which may
+// legitimately not be JSON when a step expects an error.
+func scenarioCallResponse(statusCode int, decoded interface{}, err error) (*scenarioResponse, error) {
+	var decodeErr *marketplace.DecodeError
+	if errors.As(err, &decodeErr) {
+		return &scenarioResponse{statusCode: decodeErr.StatusCode, body: decodeErr.Body}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	body, _ := json.Marshal(decoded)
+	return &scenarioResponse{statusCode: statusCode, body: body, decoded: decoded}, nil
+}
+
+// scenarioPageResponse converts the result of a healthcheck or dashboard
+// request, where a non-200 status is left for the step's expectations to judge.
+func scenarioPageResponse(statusCode int, body string, err error) (*scenarioResponse, error) {
+	var httpErr *marketplace.HTTPError
+	if errors.As(err, &httpErr) {
+		return newScenarioResponse(httpErr.StatusCode, httpErr.Body), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newScenarioResponse(statusCode, []byte(body)), nil
+}
+
+// jsonEqual compares two values after normalizing them through JSON, so that
+// e.g. a YAML int matches a JSON number.
+func jsonEqual(a interface{}, b interface{}) bool {
+	normalize := func(v interface{}) interface{} {
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return v
+		}
+		var decoded interface{}
+		json.Unmarshal(encoded, &decoded)
+		return decoded
+	}
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
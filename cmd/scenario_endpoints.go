@@ -0,0 +1,169 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/quiknode-labs/qn-marketplace-cli/marketplace"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/cobra"
+)
+
+// scenarioMultiEndpointCmd represents the scenario multi-endpoint command
+var scenarioMultiEndpointCmd = &cobra.Command{
+	Use:   "multi-endpoint",
+	Short: "Provisions several endpoints for one QuickNode account and checks they are isolated",
+	Long: `Use this command to make sure your add-on handles accounts with many endpoints.
+
+It provisions --endpoints endpoints for the same quicknode-id, deactivates the first one and checks,
+with RPC and/or REST calls made with each endpoint's instance headers, that:
+  - every endpoint has access after being provisioned
+  - the deactivated endpoint is refused with a 4xx while the remaining endpoints still work
+  - deprovisioning the account cuts off all of them
+
+It uses the same base URL and routes as pudd. Pass --rpc-url and --rpc-method, and/or --rest-url.
+`,
+	Args: cobra.OnlyValidArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		printHeader("SCENARIO: MULTI-ENDPOINT")
+		verbose := cmd.Flag("verbose").Value.String() == "true"
+		keepGoing := cmd.Flag("continue-on-failure").Value.String() == "true"
+		baseUrl := cmd.Flag("base-url").Value.String()
+		if baseUrl == "" {
+			fmt.Print("Please provide a base URL for the provisioning API via the --base-url flag\n")
+			os.Exit(1)
+		}
+		count, _ := cmd.Flags().GetInt("endpoints")
+		if count < 2 {
+			color.Red("Please provide at least 2 endpoints via the --endpoints flag\n")
+			os.Exit(1)
+		}
+
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		results := newResults(cmd, "multi-endpoint")
+		client := newClient(cmd, marketplace.WithBaseURL(baseUrl), results.recording())
+		routes, err := newRoutes(cmd, client)
+		if err != nil {
+			color.Red("%s", err)
+			os.Exit(1)
+		}
+		quicknodeId := cmd.Flag("quicknode-id").Value.String()
+		access, err := newAccessProbe(cmd, client, marketplace.InstanceHeaders{
+			QuickNodeId: quicknodeId,
+			Chain:       cmd.Flag("chain").Value.String(),
+			Network:     cmd.Flag("network").Value.String(),
+		})
+		if err != nil {
+			color.Red("%s", err)
+			os.Exit(1)
+		}
+		if access == nil {
+			color.Red("Please provide a URL to check each endpoint's access with via the --rpc-url or --rest-url flag\n")
+			os.Exit(1)
+		}
+
+		endpointIds := make([]string, count)
+		endpoints := make([]*accessProbe, count)
+		for i := range endpointIds {
+			endpointIds[i] = uuid.NewV4().String()
+			endpoints[i] = access.forEndpoint(fmt.Sprintf("endpoint #%d", i+1), endpointIds[i])
+		}
+
+		// Provision every endpoint on the same account
+		provisioned := make([]bool, count)
+		for i, endpointId := range endpointIds {
+			provisionUrl := routes.url("provision", quicknodeId, endpointId)
+			request := provisionRequest(cmd, quicknodeId, endpointId, cmd.Flag("plan").Value.String())
+			if verbose {
+				requestJson, _ := json.MarshalIndent(request, "", "  ")
+				color.Blue("\n\n→ POST %s:\n", provisionUrl)
+				fmt.Printf("%s\n", requestJson)
+			}
+			provisioned[i] = results.run(fmt.Sprintf("Provision of endpoint #%d was successful", i+1), keepGoing, func() error {
+				_, err := client.Provision(ctx, provisionUrl, request)
+				return err
+			})
+		}
+		for i, endpoint := range endpoints {
+			if provisioned[i] {
+				endpoint.check(ctx, results, keepGoing, "provision", true)
+			}
+		}
+
+		// Deactivate the first endpoint only
+		deactivateUrl := routes.url("deactivate_endpoint", quicknodeId, endpointIds[0])
+		deactivatePayload := deactivateRequest(cmd, quicknodeId, endpointIds[0])
+		if verbose {
+			requestJson, _ := json.MarshalIndent(deactivatePayload, "", "  ")
+			color.Blue("\n\n→ DELETE %s:\n", deactivateUrl)
+			fmt.Printf("%s\n", requestJson)
+		}
+		deactivated := results.run("Deactivate of endpoint #1 was successful", keepGoing, func() error {
+			_, err := client.Deactivate(ctx, deactivateUrl, deactivatePayload)
+			return err
+		})
+		if deactivated {
+			for i, endpoint := range endpoints {
+				if provisioned[i] {
+					endpoint.check(ctx, results, keepGoing, "deactivating endpoint #1", i > 0)
+				}
+			}
+		}
+
+		// Deprovisioning the account must remove every endpoint
+		deprovisionUrl := routes.url("deprovision", quicknodeId, "")
+		deprovisionPayload := deprovisionRequest(cmd, quicknodeId)
+		if verbose {
+			requestJson, _ := json.MarshalIndent(deprovisionPayload, "", "  ")
+			color.Blue("\n\n→ DELETE %s:\n", deprovisionUrl)
+			fmt.Printf("%s\n", requestJson)
+		}
+		deprovisioned := results.run("Deprovision was successful", keepGoing, func() error {
+			_, err := client.Deprovision(ctx, deprovisionUrl, deprovisionPayload)
+			return err
+		})
+		if deprovisioned {
+			for i, endpoint := range endpoints {
+				if provisioned[i] {
+					endpoint.check(ctx, results, keepGoing, "deprovision", false)
+				}
+			}
+		}
+
+		if keepGoing {
+			results.printSummary()
+		}
+		results.finish()
+	},
+}
+
+func init() {
+	scenarioCmd.AddCommand(scenarioMultiEndpointCmd)
+
+	scenarioMultiEndpointCmd.PersistentFlags().StringP("base-url", "u", "", "The base URL of the add-on's provisioning API")
+
+	// Note: basic auth defaults to username = Aladdin and password = open sesame
+	scenarioMultiEndpointCmd.PersistentFlags().String("basic-auth", "QWxhZGRpbjpvcGVuIHNlc2FtZQ==", "The basic auth credentials for the add-on. Defaults to username = Aladdin and password = open sesame")
+
+	scenarioMultiEndpointCmd.PersistentFlags().StringP("quicknode-id", "q", uuid.NewV4().String(), "The QuickNode ID to provision the endpoints for (optional)")
+	scenarioMultiEndpointCmd.PersistentFlags().Int("endpoints", 3, "The number of endpoints to provision for the QuickNode ID")
+	scenarioMultiEndpointCmd.PersistentFlags().StringP("endpoint-url", "l", "https://long-late-firefly.quiknode.pro/4bb1e6b2dec8294938b6fdfdb7cf0cf70c4e97a2/", "The endpoint URL to provision the add-on for (optional - defaults to an ethereum mainnet endpoint")
+	scenarioMultiEndpointCmd.PersistentFlags().StringP("wss-url", "w", "wss://long-late-firefly.quiknode.pro/4bb1e6b2dec8294938b6fdfdb7cf0cf70c4e97a2/", "The WSS URL to provision the add-on for (optional - defaults to an ethereum mainnet endpoint")
+	scenarioMultiEndpointCmd.PersistentFlags().StringP("chain", "c", "ethereum", "The chain to provision the add-on for")
+	scenarioMultiEndpointCmd.PersistentFlags().StringP("network", "n", "mainnet", "The network to provision the add-on for")
+	scenarioMultiEndpointCmd.PersistentFlags().StringP("plan", "p", "discover", "The plan to provision the add-on for")
+	scenarioMultiEndpointCmd.PersistentFlags().StringP("add-on-id", "i", "33", "The ID of the add-on to provision")
+	scenarioMultiEndpointCmd.PersistentFlags().StringP("add-on-slug", "s", "myslug", "The slug of the add-on to provision")
+	addWhitelistFlags(scenarioMultiEndpointCmd)
+
+	addRouteFlags(scenarioMultiEndpointCmd)
+	addAccessFlags(scenarioMultiEndpointCmd)
+
+	scenarioMultiEndpointCmd.PersistentFlags().Bool("continue-on-failure", false, "Run every step even if an earlier one failed, then print a summary of all the results")
+}
@@ -0,0 +1,334 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// scenarioFile is a declarative description of a sequence of calls to an add-on.
+type scenarioFile struct {
+	Name     string            `yaml:"name"`
+	BaseURL  string            `yaml:"base-url"`
+	Vars     map[string]string `yaml:"vars"`
+	Instance scenarioInstance  `yaml:"instance"`
+	Steps    []scenarioStep    `yaml:"steps"`
+}
+
+// defaultScenarioChain is the chain of scenarios that don't set one.
+const defaultScenarioChain = "ethereum"
+
+// scenarioInstance holds the data QuickNode sends about the instance being
+// provisioned. Steps can override any of these fields.
+type scenarioInstance struct {
+	BasicAuth   string `yaml:"basic-auth"`
+	QuickNodeId string `yaml:"quicknode-id"`
+	EndpointId  string `yaml:"endpoint-id"`
+	EndpointURL string `yaml:"endpoint-url"`
+	WSSURL      string `yaml:"wss-url"`
+	Chain       string `yaml:"chain"`
+	Network     string `yaml:"network"`
+	Plan        string `yaml:"plan"`
+	AddOnId     string `yaml:"add-on-id"`
+	AddOnSlug   string `yaml:"add-on-slug"`
+
+	// Lists are pointers so that an empty list can override a default one
+	Referers          *[]string `yaml:"referers"`
+	ContractAddresses *[]string `yaml:"contract-addresses"`
+
+	AllowInvalidContractAddresses *bool `yaml:"allow-invalid-contract-addresses"`
+}
+
+type scenarioStep struct {
+	Name     string                 `yaml:"name"`
+	Action   string                 `yaml:"action"`
+	URL      string                 `yaml:"url"`
+	Instance scenarioInstance       `yaml:"instance"`
+	Payload  map[string]interface{} `yaml:"payload"`
+	Method   string                 `yaml:"method"`
+	Params   []interface{}          `yaml:"params"`
+	Verb     string                 `yaml:"verb"`
+	Body     string                 `yaml:"body"`
+	SSO      scenarioSSO            `yaml:"sso"`
+	Expect   scenarioExpect         `yaml:"expect"`
+	Save     map[string]string      `yaml:"save"`
+}
+
+// scenarioSSO configures the token of an sso step, like the sso command's
+// flags. jwt-claim-names maps claims to their new names.
+type scenarioSSO struct {
+	JWTSecret     string            `yaml:"jwt-secret"`
+	JWTAlgorithm  string            `yaml:"jwt-algorithm"`
+	JWTPrivateKey string            `yaml:"jwt-private-key"`
+	JWTTTL        time.Duration     `yaml:"jwt-ttl"`
+	JWTClaims     string            `yaml:"jwt-claims"`
+	JWTClaimNames map[string]string `yaml:"jwt-claim-names"`
+	Name          string            `yaml:"name"`
+	Email         string            `yaml:"email"`
+	Org           string            `yaml:"org"`
+}
+
+type scenarioExpect struct {
+	Status   statusCodes            `yaml:"status"`
+	Body     map[string]interface{} `yaml:"body"`
+	Contains string                 `yaml:"contains"`
+}
+
+// statusCodes accepts either a single status code or a list of them.
+type statusCodes []int
+
+func (s *statusCodes) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var code int
+		if err := value.Decode(&code); err != nil {
+			return err
+		}
+		*s = statusCodes{code}
+		return nil
+	}
+	var codes []int
+	if err := value.Decode(&codes); err != nil {
+		return err
+	}
+	*s = codes
+	return nil
+}
+
+func (s statusCodes) contains(code int) bool {
+	for _, c := range s {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+var scenarioActions = map[string]bool{
+	"provision":   true,
+	"update":      true,
+	"deactivate":  true,
+	"deprovision": true,
+	"rpc":         true,
+	"rest":        true,
+	"sso":         true,
+	"healthcheck": true,
+}
+
+func loadScenarioFile(path string) (*scenarioFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var scenario scenarioFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&scenario); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if scenario.Name == "" {
+		scenario.Name = path
+	}
+
+	for i, step := range scenario.Steps {
+		if !scenarioActions[step.Action] {
+			return nil, fmt.Errorf("%s: step #%d has an unknown action %q", path, i+1, step.Action)
+		}
+		if step.Name == "" {
+			scenario.Steps[i].Name = fmt.Sprintf("#%d %s", i+1, step.Action)
+		}
+		if step.Action == "provision" || step.Action == "update" {
+			instance := scenarioInstance{Chain: defaultScenarioChain}.merge(scenario.Instance).merge(step.Instance)
+			if err := instance.checkContractAddresses(); err != nil {
+				return nil, fmt.Errorf("%s: step %s: %w", path, scenario.Steps[i].Name, err)
+			}
+		}
+	}
+
+	return &scenario, nil
+}
+
+// merge returns the instance with the non-empty fields of override applied.
+func (i scenarioInstance) merge(override scenarioInstance) scenarioInstance {
+	pick := func(a, b string) string {
+		if b != "" {
+			return b
+		}
+		return a
+	}
+	pickList := func(a, b *[]string) *[]string {
+		if b != nil {
+			return b
+		}
+		return a
+	}
+	pickBool := func(a, b *bool) *bool {
+		if b != nil {
+			return b
+		}
+		return a
+	}
+	return scenarioInstance{
+		BasicAuth:   pick(i.BasicAuth, override.BasicAuth),
+		QuickNodeId: pick(i.QuickNodeId, override.QuickNodeId),
+		EndpointId:  pick(i.EndpointId, override.EndpointId),
+		EndpointURL: pick(i.EndpointURL, override.EndpointURL),
+		WSSURL:      pick(i.WSSURL, override.WSSURL),
+		Chain:       pick(i.Chain, override.Chain),
+		Network:     pick(i.Network, override.Network),
+		Plan:        pick(i.Plan, override.Plan),
+		AddOnId:     pick(i.AddOnId, override.AddOnId),
+		AddOnSlug:   pick(i.AddOnSlug, override.AddOnSlug),
+
+		Referers:          pickList(i.Referers, override.Referers),
+		ContractAddresses: pickList(i.ContractAddresses, override.ContractAddresses),
+
+		AllowInvalidContractAddresses: pickBool(i.AllowInvalidContractAddresses, override.AllowInvalidContractAddresses),
+	}
+}
+
+// expandInstance renders the templates in every field of the instance.
+func (i scenarioInstance) expand(vars map[string]string) (scenarioInstance, error) {
+	fields := []*string{&i.BasicAuth, &i.QuickNodeId, &i.EndpointId, &i.EndpointURL, &i.WSSURL, &i.Chain, &i.Network, &i.Plan, &i.AddOnId, &i.AddOnSlug}
+	for _, list := range []**[]string{&i.Referers, &i.ContractAddresses} {
+		if *list != nil {
+			values := append([]string{}, **list...)
+			*list = &values
+			for j := range values {
+				fields = append(fields, &values[j])
+			}
+		}
+	}
+	for _, field := range fields {
+		expanded, err := expandTemplate(*field, vars)
+		if err != nil {
+			return i, err
+		}
+		*field = expanded
+	}
+	return i, nil
+}
+
+// whitelist returns the referers and contract addresses of the instance, with
+// the same defaults as the --referer and --contract-address flags.
+func (i scenarioInstance) whitelist() ([]string, []string) {
+	referers := []string{defaultReferer}
+	if i.Referers != nil {
+		referers = append([]string{}, *i.Referers...)
+	}
+	contractAddresses := []string{defaultContractAddress}
+	if format, ok := chainAddressFormats[strings.ToLower(i.Chain)]; ok {
+		contractAddresses = []string{format.Example}
+	}
+	if i.ContractAddresses != nil {
+		contractAddresses = append([]string{}, *i.ContractAddresses...)
+	}
+	return referers, contractAddresses
+}
+
+// checkContractAddresses returns an error if one of the instance's contract
+// addresses doesn't match its chain's format, unless it allows invalid ones
+// like --allow-invalid-contract-addresses. Templates aren't checked until
+// they are rendered.
+func (i scenarioInstance) checkContractAddresses() error {
+	if i.ContractAddresses == nil || (i.AllowInvalidContractAddresses != nil && *i.AllowInvalidContractAddresses) {
+		return nil
+	}
+	format, ok := chainAddressFormats[strings.ToLower(i.Chain)]
+	if !ok {
+		return nil
+	}
+	for _, address := range *i.ContractAddresses {
+		if !strings.Contains(address, "{{") && !format.Pattern.MatchString(address) {
+			return fmt.Errorf("invalid contract address %q: expected %s for %s (set allow-invalid-contract-addresses to send it anyway)", address, format.Name, i.Chain)
+		}
+	}
+	return nil
+}
+
+var templateFuncs = template.FuncMap{
+	"uuid": func() string { return uuid.NewV4().String() },
+	"now":  func() int64 { return time.Now().Unix() },
+	"env":  os.Getenv,
+}
+
+// expandTemplate renders s as a Go template, with vars available as {{ .name }}.
+func expandTemplate(s string, vars map[string]string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	tmpl, err := template.New("").Funcs(templateFuncs).Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// expandValue renders the templates in every string of a decoded YAML/JSON value.
+func expandValue(value interface{}, vars map[string]string) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return expandTemplate(v, vars)
+	case map[string]interface{}:
+		expanded := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			e, err := expandValue(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			expanded[key] = e
+		}
+		return expanded, nil
+	case []interface{}:
+		expanded := make([]interface{}, len(v))
+		for i, item := range v {
+			e, err := expandValue(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			expanded[i] = e
+		}
+		return expanded, nil
+	default:
+		return value, nil
+	}
+}
+
+// lookupPath finds a value in a decoded JSON body by a dot-separated path,
+// where numeric segments index into arrays, e.g. "result.items.0.id".
+func lookupPath(body interface{}, path string) (interface{}, bool) {
+	current := body
+	for _, segment := range strings.Split(path, ".") {
+		switch v := current.(type) {
+		case map[string]interface{}:
+			item, ok := v[segment]
+			if !ok {
+				return nil, false
+			}
+			current = item
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, false
+			}
+			current = v[index]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
@@ -4,13 +4,18 @@ Copyright © 2023 QuickNode, Inc.
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"html"
 	"log"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"os/exec"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/quiknode-labs/qn-marketplace-cli/marketplace"
@@ -23,12 +28,19 @@ var ssoCmd = &cobra.Command{
 	Use:   "sso",
 	Short: "Allows you to test your add-on's SSO implementation",
 	Long: `Use this command to make sure your add-on's SSO implementation is working as expected.
+
+It also checks that the dashboard rejects expired, not yet valid, wrongly signed, unsigned,
+incomplete and truncated tokens (skip these checks with --skip-invalid-tokens).
+
+Without --with-browser, it also audits how the dashboard handles the token it gets in its URL:
+whether it redirects to a URL without it, sets a Referrer-Policy, Secure, HttpOnly and SameSite
+session cookies, a Content-Security-Policy and X-Frame-Options. Findings are reported as
+warnings of low, medium or high severity, and fail the command from --audit-fail-on.
 	
 Learn more at https://www.quicknode.com/guides/quicknode-products/marketplace/how-sso-works-for-marketplace-partners/
 	`,
 	Run: func(cmd *cobra.Command, args []string) {
-		header := color.New(color.FgWhite, color.BgBlue).SprintFunc()
-		fmt.Printf("%s\n\n", header("        SSO        "))
+		printHeader("SSO")
 		verbose := cmd.Flag("verbose").Value.String() == "true"
 		withBrowser := cmd.Flag("with-browser").Value.String() == "true"
 		provisionURL := cmd.Flag("url").Value.String()
@@ -36,20 +48,32 @@ Learn more at https://www.quicknode.com/guides/quicknode-products/marketplace/ho
 			fmt.Print("Please provide a URL for the provision API via the --url flag\n")
 			os.Exit(1)
 		}
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		results := newResults(cmd, "sso")
+		client := newClient(cmd, results.recording())
+		cleanup := newRunCleanup(cmd, client, results, nil)
 
-	request := marketplace.ProvisionRequest{
-		QuickNodeId:       cmd.Flag("quicknode-id").Value.String(),
-		EndpointId:        cmd.Flag("endpoint-id").Value.String(),
-		Chain:             cmd.Flag("chain").Value.String(),
-		Network:           cmd.Flag("network").Value.String(),
-		Plan:              cmd.Flag("plan").Value.String(),
-		WSSURL:            cmd.Flag("wss-url").Value.String(),
-		HTTPURL:           cmd.Flag("endpoint-url").Value.String(),
-		Referers:          []string{"https://quicknode.com"},
-		ContractAddresses: []string{"0x4d224452801ACEd8B2F0aebE155379bb5D594381"},
-		AddOnSlug:         cmd.Flag("add-on-slug").Value.String(),
-		AddOnId:           cmd.Flag("add-on-id").Value.String(),
-	}
+		tokenOptions := jwtOptions(cmd)
+		auditFailOn := cmd.Flag("audit-fail-on").Value.String()
+		if auditFailOn != "none" && severityRank(auditFailOn) == len(severities) {
+			color.Red("Invalid --audit-fail-on %q, expected none, %s", auditFailOn, strings.Join(severities, ", "))
+			os.Exit(1)
+		}
+		referers, contractAddresses := whitelist(cmd)
+		request := marketplace.ProvisionRequest{
+			QuickNodeId:       cmd.Flag("quicknode-id").Value.String(),
+			EndpointId:        cmd.Flag("endpoint-id").Value.String(),
+			Chain:             cmd.Flag("chain").Value.String(),
+			Network:           cmd.Flag("network").Value.String(),
+			Plan:              cmd.Flag("plan").Value.String(),
+			WSSURL:            cmd.Flag("wss-url").Value.String(),
+			HTTPURL:           cmd.Flag("endpoint-url").Value.String(),
+			Referers:          referers,
+			ContractAddresses: contractAddresses,
+			AddOnSlug:         cmd.Flag("add-on-slug").Value.String(),
+			AddOnId:           cmd.Flag("add-on-id").Value.String(),
+		}
 
 		if verbose {
 			color.Blue("→ POST %s:\n", provisionURL)
@@ -59,23 +83,27 @@ Learn more at https://www.quicknode.com/guides/quicknode-products/marketplace/ho
 			fmt.Printf("%s\n", requestJson)
 		}
 
-		provisionResponse, err := marketplace.Provision(provisionURL, request, cmd.Flag("basic-auth").Value.String())
-		if err != nil {
-			color.Red("%s", err)
-			os.Exit(1)
-		}
-		if verbose {
-			fmt.Printf("\nProvision was successful:\n")
-			fmt.Printf("  Status:     %s\n", provisionResponse.Status)
-			fmt.Printf("  Dashboard URL:     %s\n", provisionResponse.DashboardURL)
-			fmt.Printf("  Access URL:     %s\n\n", provisionResponse.AccessURL)
-		}
+		var dashboardURL string
+		cleanup.track(provisionURL, request)
+		results.run("Provision was successful", false, func() error {
+			provisionResponse, err := client.Provision(ctx, provisionURL, request)
+			if err != nil {
+				return err
+			}
+			if verbose {
+				fmt.Printf("\nProvision was successful:\n")
+				fmt.Printf("  Status:     %s\n", provisionResponse.Status)
+				fmt.Printf("  Dashboard URL:     %s\n", provisionResponse.DashboardURL)
+				fmt.Printf("  Access URL:     %s\n\n", provisionResponse.AccessURL)
+			}
+			recordProvisioned(cmd, provisionURL, request, provisionResponse)
 
-		dashboardURL := provisionResponse.DashboardURL
-		if dashboardURL == "" {
-			color.Red("The server did not return a dashboard-url. Please make sure your provision endpoint is returning the correct response.\n")
-			os.Exit(1)
-		}
+			dashboardURL = provisionResponse.DashboardURL
+			if dashboardURL == "" {
+				return fmt.Errorf("the server did not return a dashboard-url. Please make sure your provision endpoint is returning the correct response")
+			}
+			return nil
+		})
 
 		user := marketplace.User{
 			QuicknodeID:      cmd.Flag("quicknode-id").Value.String(),
@@ -89,9 +117,10 @@ Learn more at https://www.quicknode.com/guides/quicknode-products/marketplace/ho
 		}
 
 		jwtSecret := cmd.Flag("jwt-secret").Value.String()
-		jwtToken, err := marketplace.GetJWT(jwtSecret, user)
+		jwtToken, err := marketplace.GetJWT(jwtSecret, user, tokenOptions...)
 		if err != nil {
 			color.Red("Could not generate JWT: %s", err)
+			cleanup.clean()
 			os.Exit(1)
 		}
 
@@ -99,33 +128,88 @@ Learn more at https://www.quicknode.com/guides/quicknode-products/marketplace/ho
 			fmt.Printf("JWT Token: %s\n\n", jwtToken)
 		}
 
-		dashboardUrlWithJwtToken := fmt.Sprintf("%s?jwt=%s", dashboardURL, jwtToken)
+		dashboardUrlWithJwtToken, err := withToken(dashboardURL, jwtToken)
+		if err != nil {
+			color.Red("%s", err)
+			cleanup.clean()
+			os.Exit(1)
+		}
 
+		// Invalid tokens must not land where a valid one does, showing what it shows
+		landing := dashboardURL
+		markers := authenticatedMarkers(cmd, user)
 		if withBrowser {
 			color.Yellow("  ✓ SSO attempt was completed. Please check your browser to make sure you are logged in to the dashboard.\n")
 
 			// # Open the browser
 			openbrowser(dashboardUrlWithJwtToken)
 		} else {
-			statusCode, responseBody, err := marketplace.OpenDashboard(dashboardUrlWithJwtToken)
+			session, err := client.NewDashboardSession()
 			if err != nil {
-				color.Red("  ✘ Could not open dashboard: %s", err)
+				color.Red("Could not start a dashboard session: %s", err)
+				cleanup.clean()
 				os.Exit(1)
 			}
-			if statusCode != http.StatusOK {
-				color.Red("  ✘ Could not open dashboard: status code = %d\n\n", statusCode)
-				os.Exit(1)
-			} else {
+			var dashboard *marketplace.DashboardResponse
+			visited := time.Now()
+			passed := results.run("SSO was successful", false, func() error {
+				dashboard, err = session.Visit(ctx, dashboardUrlWithJwtToken)
+				if err != nil {
+					return fmt.Errorf("could not open dashboard: %w", err)
+				}
 				if verbose {
-					fmt.Printf("Status Code: %d\nResponse Body:\n", statusCode)
-					fmt.Printf(responseBody)
+					fmt.Printf("Status Code: %d\nResponse Body:\n", dashboard.StatusCode)
+					fmt.Print(dashboard.Body)
 					fmt.Printf("\n")
 				}
-				color.Green("  ✓ SSO was successful.")
-				color.Blue("  → SSO into %s:\n", dashboardUrlWithJwtToken)
+				if dashboard.StatusCode != http.StatusOK {
+					return fmt.Errorf("could not open dashboard: GET %s responded with status code %d%s", dashboard.URL, dashboard.StatusCode, redirectTrace(dashboard))
+				}
+				landing = dashboard.URL
+				return nil
+			})
+			if passed && outputFormat == outputText {
+				color.Blue("  → SSO into %s:%s\n", dashboardUrlWithJwtToken, redirectTrace(dashboard))
+			}
+
+			authenticatedURL, err := authenticatedPage(cmd, dashboardURL, landing)
+			if err != nil {
+				color.Red("%s", err)
+				cleanup.clean()
+				os.Exit(1)
+			}
+			results.run("SSO established a session", true, func() error {
+				return checkSession(ctx, session, authenticatedURL, markers, verbose)
+			})
+
+			if passed && cmd.Flag("skip-audit").Value.String() != "true" {
+				recordAudit(results, auditSSO(dashboard, jwtToken), visited, auditFailOn)
 			}
+		}
 
+		if cmd.Flag("skip-invalid-tokens").Value.String() != "true" {
+			invalidTokens, err := marketplace.GetInvalidJWTs(jwtSecret, user, tokenOptions...)
+			if err != nil {
+				color.Red("Could not generate invalid JWTs: %s", err)
+				cleanup.clean()
+				os.Exit(1)
+			}
+			for _, invalid := range invalidTokens {
+				invalid := invalid
+				if verbose {
+					fmt.Printf("JWT Token (%s): %s\n", invalid.Name, invalid.Token)
+				}
+				results.run(fmt.Sprintf("Dashboard rejected %s", invalid.Name), true, func() error {
+					url, err := withToken(dashboardURL, invalid.Token)
+					if err != nil {
+						return err
+					}
+					return checkRejected(ctx, client, url, landing, markers)
+				})
+			}
 		}
+
+		results.finish()
 	},
 }
 
@@ -146,15 +230,148 @@ func init() {
 	ssoCmd.PersistentFlags().StringP("plan", "p", "discover", "The plan to provision the add-on for")
 	ssoCmd.PersistentFlags().StringP("add-on-id", "i", "33", "The ID of the add-on to provision")
 	ssoCmd.PersistentFlags().StringP("add-on-slug", "s", "myslug", "The slug of the add-on to provision")
+	addWhitelistFlags(ssoCmd)
+	addInstanceNameFlag(ssoCmd)
+	addCleanupFlags(ssoCmd)
 
 	ssoCmd.PersistentFlags().StringP("jwt-secret", "j", "", "The JWT secret for the add-on")
+	addJWTFlags(ssoCmd)
 	ssoCmd.PersistentFlags().String("name", "", "The name of the user trying to SSO into the add-on")
 	ssoCmd.PersistentFlags().String("email", "", "The email of the user trying to SSO into the add-on")
 	ssoCmd.PersistentFlags().String("org", "", "The organization name for the user trying to SSO into the add-on")
 
+	ssoCmd.PersistentFlags().String("authenticated-url", "", "A page only logged in users can see, requested after SSO to check a session was established (absolute or relative to the dashboard-url). Defaults to the page SSO lands on")
+	ssoCmd.PersistentFlags().StringArray("authenticated-text", []string{}, "Text the authenticated page must contain (can be repeated, any one will do). Defaults to the user's --name or --email")
+	ssoCmd.PersistentFlags().Bool("skip-invalid-tokens", false, "Don't check that the dashboard rejects expired, wrongly signed, unsigned, incomplete and truncated tokens")
+	ssoCmd.PersistentFlags().Bool("skip-audit", false, "Don't audit what the dashboard does with the token in its URL and the security headers and cookies of the page SSO lands on")
+	ssoCmd.PersistentFlags().String("audit-fail-on", "none", "The severity (low, medium or high) from which audit findings fail the command instead of being reported as warnings, or none")
 	ssoCmd.PersistentFlags().Bool("with-browser", false, "Open the dashboard (with SSO) in browser instead of making a headless GET request")
 }
 
+// checkRejected opens the dashboard with an invalid token and returns an error
+// if the dashboard let it in, i.e. answered with a 2xx from the page a valid
+// token lands on, showing one of the markers if there are any, rather than an
+// error or a redirect elsewhere.
+func checkRejected(ctx context.Context, client *marketplace.Client, url string, landing string, markers []string) error {
+	dashboard, err := client.VisitDashboard(ctx, url)
+	if err != nil {
+		return err
+	}
+	if dashboard.StatusCode >= 500 {
+		return fmt.Errorf("the dashboard crashed with status code %d instead of rejecting the token", dashboard.StatusCode)
+	}
+	if dashboard.StatusCode >= 200 && dashboard.StatusCode < 300 && samePage(dashboard.URL, landing) && (len(markers) == 0 || containsAny(dashboard.Body, markers)) {
+		return fmt.Errorf("the dashboard accepted the token with status code %d on %s", dashboard.StatusCode, withoutQuery(dashboard.URL))
+	}
+	return nil
+}
+
+// checkSession requests an authenticated page in the session SSO started and
+// returns an error unless it is served, without a redirect (e.g. to a login
+// page), and shows one of the markers if there are any.
+func checkSession(ctx context.Context, session *marketplace.DashboardSession, url string, markers []string, verbose bool) error {
+	page, err := session.Visit(ctx, url)
+	if err != nil {
+		return err
+	}
+	if verbose {
+		fmt.Printf("Status Code: %d\nResponse Body:\n", page.StatusCode)
+		fmt.Print(page.Body)
+		fmt.Printf("\n")
+	}
+	if page.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s responded with status code %d%s", url, page.StatusCode, redirectTrace(page))
+	}
+	if !samePage(page.URL, url) {
+		return fmt.Errorf("GET %s was redirected to %s, so no session was established%s", url, withoutQuery(page.URL), redirectTrace(page))
+	}
+	if len(markers) > 0 && !containsAny(page.Body, markers) {
+		return fmt.Errorf("%s does not contain %s, so the user doesn't seem to be logged in", withoutQuery(page.URL), strings.Join(quoteAll(markers), " or "))
+	}
+	return nil
+}
+
+// authenticatedPage returns the --authenticated-url, resolved against the
+// dashboard URL, or else the page SSO landed on without its jwt parameter.
+func authenticatedPage(cmd *cobra.Command, dashboardURL string, landing string) (string, error) {
+	if page := cmd.Flag("authenticated-url").Value.String(); page != "" {
+		base, err := neturl.Parse(dashboardURL)
+		if err != nil {
+			return "", fmt.Errorf("invalid dashboard-url %q: %w", dashboardURL, err)
+		}
+		ref, err := neturl.Parse(page)
+		if err != nil {
+			return "", fmt.Errorf("invalid --authenticated-url %q: %w", page, err)
+		}
+		return base.ResolveReference(ref).String(), nil
+	}
+	page, err := neturl.Parse(landing)
+	if err != nil {
+		return landing, nil
+	}
+	query := page.Query()
+	query.Del("jwt")
+	page.RawQuery = query.Encode()
+	return page.String(), nil
+}
+
+// authenticatedMarkers returns the --authenticated-text values, or else the
+// user's name and email, one of which a logged in page must show.
+func authenticatedMarkers(cmd *cobra.Command, user marketplace.User) []string {
+	markers, _ := cmd.Flags().GetStringArray("authenticated-text")
+	if len(markers) == 0 {
+		markers = []string{user.Name, user.Email}
+	}
+	return nonEmpty(markers)
+}
+
+// containsAny reports whether body contains one of the markers, as is or HTML escaped.
+func containsAny(body string, markers []string) bool {
+	for _, marker := range markers {
+		if strings.Contains(body, marker) || strings.Contains(body, html.EscapeString(marker)) {
+			return true
+		}
+	}
+	return false
+}
+
+func quoteAll(values []string) []string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = fmt.Sprintf("%q", value)
+	}
+	return quoted
+}
+
+// redirectTrace describes the redirects followed to reach a page, one per line.
+func redirectTrace(page *marketplace.DashboardResponse) string {
+	var trace strings.Builder
+	for _, redirect := range page.Redirects {
+		fmt.Fprintf(&trace, "\n      %d %s → %s", redirect.StatusCode, withoutQuery(redirect.URL), withoutQuery(redirect.Location))
+	}
+	return trace.String()
+}
+
+// withoutQuery returns url without its query string, e.g. to leave tokens out of messages.
+func withoutQuery(url string) string {
+	parsed, err := neturl.Parse(url)
+	if err != nil {
+		return url
+	}
+	parsed.RawQuery = ""
+	return parsed.String()
+}
+
+// samePage reports whether two URLs point at the same page, ignoring their query strings.
+func samePage(a string, b string) bool {
+	urlA, errA := neturl.Parse(a)
+	urlB, errB := neturl.Parse(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return urlA.Host == urlB.Host && strings.TrimRight(urlA.Path, "/") == strings.TrimRight(urlB.Path, "/")
+}
+
 func openbrowser(url string) {
 	var err error
 
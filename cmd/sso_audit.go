@@ -0,0 +1,157 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	"github.com/quiknode-labs/qn-marketplace-cli/marketplace"
+)
+
+// severities of the audit's findings, from the least to the most severe.
+var severities = []string{"low", "medium", "high"}
+
+// auditFinding is a weakness found by the SSO audit.
+type auditFinding struct {
+	Severity string
+	Message  string
+}
+
+// auditCheck is one check of the SSO audit and what it found.
+type auditCheck struct {
+	Name     string
+	Findings []auditFinding
+}
+
+// severityRank returns the position of a severity in severities, or
+// len(severities) for "none", which no finding reaches.
+func severityRank(severity string) int {
+	for i, s := range severities {
+		if s == severity {
+			return i
+		}
+	}
+	return len(severities)
+}
+
+// auditSSO checks what the dashboard does with the token it got in its URL,
+// given the page SSO landed on, and how that page protects the session.
+func auditSSO(dashboard *marketplace.DashboardResponse, token string) []auditCheck {
+	landing, _ := neturl.Parse(dashboard.URL)
+	tokenInURL := strings.Contains(dashboard.URL, token) || (landing != nil && landing.Query().Get("jwt") != "")
+	https := landing != nil && landing.Scheme == "https"
+
+	stripped := auditCheck{Name: "Dashboard removes the token from the URL"}
+	if tokenInURL {
+		stripped.Findings = append(stripped.Findings, auditFinding{"high", fmt.Sprintf("the page SSO lands on (%s) still has the token in its URL, where it ends up in the browser history, server logs and Referer headers. Redirect to a URL without it once the session is set", withoutQuery(dashboard.URL))})
+	}
+
+	referrer := auditCheck{Name: "Dashboard sets a Referrer-Policy"}
+	policy := lastValue(dashboard.Header.Get("Referrer-Policy"))
+	switch {
+	case policy == "" && tokenInURL:
+		referrer.Findings = append(referrer.Findings, auditFinding{"medium", "the landing page has no Referrer-Policy header, so browsers may send its URL and the token in it to other sites. Set it to no-referrer or same-origin"})
+	case policy == "":
+		referrer.Findings = append(referrer.Findings, auditFinding{"low", "the landing page has no Referrer-Policy header. Set it to no-referrer, same-origin or strict-origin-when-cross-origin"})
+	case policy == "unsafe-url" || policy == "no-referrer-when-downgrade":
+		severity := "medium"
+		if tokenInURL {
+			severity = "high"
+		}
+		referrer.Findings = append(referrer.Findings, auditFinding{severity, fmt.Sprintf("the landing page's Referrer-Policy is %s, which sends its full URL to other sites", policy)})
+	}
+
+	cookies := auditCheck{Name: "Session cookies are Secure, HttpOnly and SameSite"}
+	for _, cookie := range ssoCookies(dashboard) {
+		if !cookie.Secure {
+			if https {
+				cookies.Findings = append(cookies.Findings, auditFinding{"medium", fmt.Sprintf("cookie %s is not Secure, so it can be sent over plain HTTP", cookie.Name)})
+			} else {
+				cookies.Findings = append(cookies.Findings, auditFinding{"low", fmt.Sprintf("cookie %s is not Secure, which it should be once the dashboard is served over HTTPS", cookie.Name)})
+			}
+		}
+		if !cookie.HttpOnly {
+			cookies.Findings = append(cookies.Findings, auditFinding{"medium", fmt.Sprintf("cookie %s is not HttpOnly, so scripts (and XSS) can read it", cookie.Name)})
+		}
+		switch cookie.SameSite {
+		case http.SameSiteLaxMode, http.SameSiteStrictMode:
+		case http.SameSiteNoneMode:
+			cookies.Findings = append(cookies.Findings, auditFinding{"low", fmt.Sprintf("cookie %s is SameSite=None, so it is sent with cross-site requests", cookie.Name)})
+		default:
+			cookies.Findings = append(cookies.Findings, auditFinding{"low", fmt.Sprintf("cookie %s has no SameSite attribute. Set it to Lax or Strict", cookie.Name)})
+		}
+	}
+
+	csp := dashboard.Header.Get("Content-Security-Policy")
+	contentPolicy := auditCheck{Name: "Dashboard sets a Content-Security-Policy"}
+	if csp == "" {
+		contentPolicy.Findings = append(contentPolicy.Findings, auditFinding{"medium", "the landing page has no Content-Security-Policy header to restrict the scripts and resources it loads"})
+	}
+
+	framing := auditCheck{Name: "Dashboard can't be framed by other sites"}
+	frameOptions := strings.ToUpper(dashboard.Header.Get("X-Frame-Options"))
+	if frameOptions != "DENY" && frameOptions != "SAMEORIGIN" && !strings.Contains(csp, "frame-ancestors") {
+		framing.Findings = append(framing.Findings, auditFinding{"medium", "the landing page has neither an X-Frame-Options header nor a frame-ancestors directive in its Content-Security-Policy, so other sites can frame it (clickjacking)"})
+	}
+
+	return []auditCheck{stripped, referrer, cookies, contentPolicy, framing}
+}
+
+// ssoCookies returns the cookies set on the way to the page SSO landed on,
+// leaving out the ones being deleted.
+func ssoCookies(dashboard *marketplace.DashboardResponse) []*http.Cookie {
+	headers := []http.Header{}
+	for _, redirect := range dashboard.Redirects {
+		headers = append(headers, redirect.Header)
+	}
+	headers = append(headers, dashboard.Header)
+
+	var cookies []*http.Cookie
+	for _, header := range headers {
+		for _, cookie := range (&http.Response{Header: header}).Cookies() {
+			if cookie.MaxAge < 0 || (!cookie.Expires.IsZero() && cookie.Expires.Before(time.Now())) {
+				continue
+			}
+			cookies = append(cookies, cookie)
+		}
+	}
+	return cookies
+}
+
+// lastValue returns the last value of a comma separated header, the one
+// browsers use, lowercased.
+func lastValue(header string) string {
+	values := strings.Split(header, ",")
+	return strings.ToLower(strings.TrimSpace(values[len(values)-1]))
+}
+
+// recordAudit records the audit's checks, made on the dashboard page
+// requested at start: a check fails if one of its findings is at least as
+// severe as failOn, and passes with a warning of its most severe finding
+// otherwise.
+func recordAudit(r *results, checks []auditCheck, start time.Time, failOn string) {
+	for _, check := range checks {
+		if len(check.Findings) == 0 {
+			r.pass(check.Name, start)
+			continue
+		}
+		severity := check.Findings[0].Severity
+		var messages []string
+		for _, finding := range check.Findings {
+			if severityRank(finding.Severity) > severityRank(severity) {
+				severity = finding.Severity
+			}
+			messages = append(messages, fmt.Sprintf("[%s] %s", finding.Severity, finding.Message))
+		}
+		message := fmt.Sprintf("%d findings: %s", len(messages), strings.Join(messages, "; "))
+		if len(check.Findings) == 1 {
+			message = check.Findings[0].Message
+		}
+		r.finding(check.Name, start, severityRank(severity) >= severityRank(failOn), severity, message)
+	}
+}
@@ -0,0 +1,354 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/quiknode-labs/qn-marketplace-cli/marketplace"
+	"github.com/spf13/cobra"
+)
+
+// Instance statuses, after the last action the CLI made on an instance.
+const (
+	instanceProvisioned   = "provisioned"
+	instanceUpdated       = "updated"
+	instanceDeactivated   = "deactivated"
+	instanceDeprovisioned = "deprovisioned"
+)
+
+// lastInstance refers to the most recently provisioned instance that is still provisioned.
+const lastInstance = "last"
+
+// instance is an add-on instance provisioned by the CLI, as recorded in the state file.
+type instance struct {
+	Name          string    `json:"name,omitempty"`
+	QuickNodeId   string    `json:"quicknode-id"`
+	EndpointId    string    `json:"endpoint-id"`
+	Chain         string    `json:"chain"`
+	Network       string    `json:"network"`
+	Plan          string    `json:"plan"`
+	AddOnId       string    `json:"add-on-id"`
+	AddOnSlug     string    `json:"add-on-slug"`
+	DashboardURL  string    `json:"dashboard-url,omitempty"`
+	AccessURL     string    `json:"access-url,omitempty"`
+	ProvisionURL  string    `json:"provision-url"`
+	Status        string    `json:"status"`
+	ProvisionedAt time.Time `json:"provisioned-at"`
+	UpdatedAt     time.Time `json:"updated-at"`
+}
+
+// instanceStore is the state file where the CLI records the instances it provisioned,
+// so that later commands can target them.
+type instanceStore struct {
+	path      string
+	Instances []*instance `json:"instances"`
+}
+
+// defaultStateFile returns the state file used when --state-file isn't set.
+func defaultStateFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "qn-marketplace-cli", "instances.json"), nil
+}
+
+// stateLockTimeout is how long a command waits for others to finish writing
+// the state file, e.g. the commands of a --matrix run.
+const stateLockTimeout = 10 * time.Second
+
+// staleStateLock is the age from which a lock file is considered left over by
+// a command that was killed while holding it.
+const staleStateLock = 30 * time.Second
+
+// stateFile returns the --state-file, or the default one.
+func stateFile(cmd *cobra.Command) (string, error) {
+	path := cmd.Flag("state-file").Value.String()
+	if path != "" {
+		return path, nil
+	}
+	path, err := defaultStateFile()
+	if err != nil {
+		return "", fmt.Errorf("could not find where to keep the instances state: %w", err)
+	}
+	return path, nil
+}
+
+// openInstanceStore reads the --state-file, which doesn't need to exist yet.
+func openInstanceStore(cmd *cobra.Command) (*instanceStore, error) {
+	path, err := stateFile(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return readInstanceStore(path)
+}
+
+func readInstanceStore(path string) (*instanceStore, error) {
+	store := &instanceStore{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return store, nil
+}
+
+// lockStateFile takes the lock file of a state file, waiting for other
+// commands to release it, and returns the function releasing it.
+func lockStateFile(path string) (func(), error) {
+	lock := path + ".lock"
+	deadline := time.Now().Add(stateLockTimeout)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > staleStateLock {
+			os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for %s, remove it if no other command is running", lock)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// save writes the state file through a temporary file in the same directory,
+// replacing it atomically. Callers must hold its lock, see recordInstance.
+func (s *instanceStore) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// find returns the instance with the given name, quicknode-id or endpoint-id,
+// or the last provisioned one.
+func (s *instanceStore) find(ref string) (*instance, error) {
+	if ref == lastInstance {
+		var last *instance
+		for _, inst := range s.Instances {
+			if inst.Status != instanceDeprovisioned && (last == nil || inst.ProvisionedAt.After(last.ProvisionedAt)) {
+				last = inst
+			}
+		}
+		if last == nil {
+			return nil, fmt.Errorf("there is no provisioned instance in %s", s.path)
+		}
+		return last, nil
+	}
+
+	var found *instance
+	for _, inst := range s.Instances {
+		if inst.Name == ref || inst.QuickNodeId == ref || inst.EndpointId == ref {
+			if found != nil && found.EndpointId != inst.EndpointId && inst.Name != ref {
+				return nil, fmt.Errorf("%q matches several instances in %s, use an endpoint-id or a name instead", ref, s.path)
+			}
+			if found == nil || inst.Name == ref {
+				found = inst
+			}
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("there is no instance named %q in %s", ref, s.path)
+	}
+	return found, nil
+}
+
+// record adds a newly provisioned instance, replacing any earlier record of the same endpoint.
+func (s *instanceStore) record(inst *instance) {
+	for i, existing := range s.Instances {
+		if existing.EndpointId == inst.EndpointId && existing.QuickNodeId == inst.QuickNodeId {
+			s.Instances[i] = inst
+			return
+		}
+	}
+	s.Instances = append(s.Instances, inst)
+}
+
+// update sets the status of the recorded instances of an account, or only of
+// one of its endpoints when endpointId isn't empty, and returns them.
+func (s *instanceStore) update(quicknodeId string, endpointId string, status string) []*instance {
+	var updated []*instance
+	for _, inst := range s.Instances {
+		if inst.QuickNodeId == quicknodeId && (endpointId == "" || inst.EndpointId == endpointId) {
+			inst.Status = status
+			inst.UpdatedAt = time.Now()
+			updated = append(updated, inst)
+		}
+	}
+	return updated
+}
+
+// addInstanceNameFlag adds the flag naming the instance a command provisions in the state file.
+func addInstanceNameFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("instance-name", "", "A name to record the provisioned instance under, to target it later with --instance")
+}
+
+// addInstanceFlag adds the --instance flag to a command that acts on an existing instance.
+func addInstanceFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("instance", "", "The recorded instance to target: its name, quicknode-id or endpoint-id, or \"last\" for the last provisioned one (see the instances command)")
+}
+
+// targetInstance sets the flags the command was not called with from the
+// instance named by --instance, exiting if it can't be found. The instance's
+// values win over those of QN_* environment variables and the config file.
+func targetInstance(cmd *cobra.Command) {
+	ref := cmd.Flag("instance").Value.String()
+	if ref == "" {
+		return
+	}
+	store, err := openInstanceStore(cmd)
+	if err == nil {
+		var inst *instance
+		if inst, err = store.find(ref); err == nil {
+			err = setInstanceFlags(cmd, inst)
+		}
+	}
+	if err != nil {
+		color.Red("%s", err)
+		os.Exit(1)
+	}
+}
+
+func setInstanceFlags(cmd *cobra.Command, inst *instance) error {
+	values := map[string]string{
+		"quicknode-id": inst.QuickNodeId,
+		"endpoint-id":  inst.EndpointId,
+		"chain":        inst.Chain,
+		"network":      inst.Network,
+		"plan":         inst.Plan,
+		"add-on-id":    inst.AddOnId,
+		"add-on-slug":  inst.AddOnSlug,
+	}
+	for name, value := range values {
+		if f := cmd.Flags().Lookup(name); f != nil && !setOnCommandLine(f) && value != "" {
+			if err := f.Value.Set(value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// newInstance returns the instance a provision request creates.
+func newInstance(provisionURL string, request marketplace.ProvisionRequest) *instance {
+	now := time.Now()
+	return &instance{
+		QuickNodeId:   request.QuickNodeId,
+		EndpointId:    request.EndpointId,
+		Chain:         request.Chain,
+		Network:       request.Network,
+		Plan:          request.Plan,
+		AddOnId:       request.AddOnId,
+		AddOnSlug:     request.AddOnSlug,
+		ProvisionURL:  provisionURL,
+		Status:        instanceProvisioned,
+		ProvisionedAt: now,
+		UpdatedAt:     now,
+	}
+}
+
+// recordProvisioned records an instance the command provisioned.
+func recordProvisioned(cmd *cobra.Command, provisionURL string, request marketplace.ProvisionRequest, response marketplace.ProvisionResponse) {
+	inst := newInstance(provisionURL, request)
+	inst.DashboardURL = response.DashboardURL
+	inst.AccessURL = response.AccessURL
+	if f := cmd.Flags().Lookup("instance-name"); f != nil {
+		inst.Name = f.Value.String()
+	}
+	recordInstance(cmd, func(store *instanceStore) bool {
+		if inst.Name != "" {
+			// Names are unique, the newest instance takes the name over
+			for _, existing := range store.Instances {
+				if existing.Name == inst.Name {
+					existing.Name = ""
+				}
+			}
+		}
+		store.record(inst)
+		return true
+	})
+}
+
+// recordStatus records the status of the instances of an account (or of one
+// of its endpoints) after an action, if the CLI provisioned them.
+func recordStatus(cmd *cobra.Command, quicknodeId string, endpointId string, status string, plan string) {
+	recordInstance(cmd, func(store *instanceStore) bool {
+		updated := store.update(quicknodeId, endpointId, status)
+		for _, inst := range updated {
+			if plan != "" {
+				inst.Plan = plan
+			}
+		}
+		return len(updated) > 0
+	})
+}
+
+// recordInstance updates the state file after an action, warning rather than
+// failing when it can't be written since the action itself succeeded. The
+// state file is locked and read again before change is applied, so commands
+// running in parallel don't overwrite each other's records. change reports
+// whether it changed anything to save.
+func recordInstance(cmd *cobra.Command, change func(store *instanceStore) bool) {
+	if err := updateStateFile(cmd, change); err != nil {
+		color.Yellow("Could not record the instance: %s", err)
+	}
+}
+
+func updateStateFile(cmd *cobra.Command, change func(store *instanceStore) bool) error {
+	path, err := stateFile(cmd)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	unlock, err := lockStateFile(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	store, err := readInstanceStore(path)
+	if err != nil {
+		return err
+	}
+	if !change(store) {
+		return nil
+	}
+	return store.save()
+}
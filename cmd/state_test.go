@@ -0,0 +1,101 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestTargetInstance(t *testing.T) {
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "instances.json")
+	store := &instanceStore{path: stateFile, Instances: []*instance{
+		{Name: "staging", QuickNodeId: "qn-staging", EndpointId: "ep-staging", Chain: "polygon", Network: "mumbai", Plan: "build", Status: instanceProvisioned},
+	}}
+	if err := store.save(); err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(dir, configFileName)
+	config := "defaults:\n  chain: solana\n  plan: discover\n  add-on-slug: config-slug\n"
+	if err := os.WriteFile(configFile, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want map[string]string
+	}{
+		{
+			name: "instance beats the config file",
+			args: []string{"--instance", "staging"},
+			want: map[string]string{"quicknode-id": "qn-staging", "chain": "polygon", "plan": "build", "add-on-slug": "config-slug"},
+		},
+		{
+			name: "instance beats the environment",
+			args: []string{"--instance", "staging"},
+			env:  map[string]string{"QN_NETWORK": "mainnet", "QN_QUICKNODE_ID": "qn-env"},
+			want: map[string]string{"quicknode-id": "qn-staging", "network": "mumbai"},
+		},
+		{
+			name: "flags beat the instance",
+			args: []string{"--instance", "staging", "--plan", "scale", "--network", "testnet"},
+			env:  map[string]string{"QN_NETWORK": "mainnet"},
+			want: map[string]string{"endpoint-id": "ep-staging", "chain": "polygon", "network": "testnet", "plan": "scale"},
+		},
+		{
+			name: "instance from the config file",
+			env:  map[string]string{"QN_INSTANCE": "last"},
+			want: map[string]string{"quicknode-id": "qn-staging", "chain": "polygon", "plan": "build"},
+		},
+		{
+			name: "no instance",
+			env:  map[string]string{"QN_NETWORK": "mainnet"},
+			want: map[string]string{"quicknode-id": "", "chain": "solana", "network": "mainnet", "plan": "discover"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+
+			root := &cobra.Command{Use: "qn"}
+			root.PersistentFlags().String("config", configFile, "")
+			root.PersistentFlags().String("profile", "", "")
+			root.PersistentFlags().Bool("no-config", false, "")
+			root.PersistentFlags().String("state-file", stateFile, "")
+			update := &cobra.Command{
+				Use: "update",
+				RunE: func(cmd *cobra.Command, args []string) error {
+					if err := applyConfig(cmd); err != nil {
+						return err
+					}
+					targetInstance(cmd)
+					return nil
+				},
+			}
+			addInstanceFlag(update)
+			for _, name := range []string{"quicknode-id", "endpoint-id", "chain", "network", "plan", "add-on-slug"} {
+				update.Flags().String(name, "", "")
+			}
+			root.AddCommand(update)
+
+			root.SetArgs(append([]string{"update"}, test.args...))
+			if err := root.Execute(); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range test.want {
+				if got := update.Flag(name).Value.String(); got != want {
+					t.Errorf("%s: got %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+// contains reports whether value is one of values.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// nonEmpty returns the non-empty values, so that a repeatable flag set to ""
+// sends an empty list.
+func nonEmpty(values []string) []string {
+	result := []string{}
+	for _, value := range values {
+		if value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
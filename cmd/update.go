@@ -23,15 +23,20 @@ var updateCmd = &cobra.Command{
 Learn more at https://www.quicknode.com/guides/quicknode-products/marketplace/how-provisioning-works-for-marketplace-partners/`,
 	Args: cobra.OnlyValidArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		header := color.New(color.FgWhite, color.BgBlue).SprintFunc()
-		fmt.Printf("%s\n\n", header("        UPDATE        "))
+		printHeader("UPDATE")
+		targetInstance(cmd)
 		verbose := cmd.Flag("verbose").Value.String() == "true"
 		url := cmd.Flag("url").Value.String()
 		if url == "" {
 			fmt.Print("Please provide a URL for the update API via the --url flag\n")
 			os.Exit(1)
 		}
+		ctx, cancel := commandContext(cmd)
+		defer cancel()
+		results := newResults(cmd, "update")
+		client := newClient(cmd, results.recording())
 
+		referers, contractAddresses := whitelist(cmd)
 		request := marketplace.UpdateRequest{
 			QuickNodeId:       cmd.Flag("quicknode-id").Value.String(),
 			EndpointId:        cmd.Flag("endpoint-id").Value.String(),
@@ -40,43 +45,41 @@ Learn more at https://www.quicknode.com/guides/quicknode-products/marketplace/ho
 			Plan:              cmd.Flag("plan").Value.String(),
 			WSSURL:            cmd.Flag("wss-url").Value.String(),
 			HTTPURL:           cmd.Flag("endpoint-url").Value.String(),
-			Referers:          []string{"https://quicknode.com"},
-			ContractAddresses: []string{"0x4d224452801ACEd8B2F0aebE155379bb5D594381"},
+			Referers:          referers,
+			ContractAddresses: contractAddresses,
 			AddOnSlug:         cmd.Flag("add-on-slug").Value.String(),
 			AddOnId:           cmd.Flag("add-on-id").Value.String(),
 		}
+		payload := requestPayload(cmd, &request)
 
 		// Check that it is protected by basic auth
-		isProtectedByBasicAuth, err := marketplace.RequiresBasicAuth(url, "PUT")
-		if err != nil {
-			color.Red("%s", err)
-			os.Exit(1)
-		}
-		if !isProtectedByBasicAuth {
-			color.Red("  ✘ The update API is not protected by basic auth.")
-			os.Exit(1)
-		}
+		results.run("Update API is protected by basic auth", false, func() error {
+			return checkBasicAuth(ctx, client, url, "PUT", "update")
+		})
 
 		if verbose {
 			color.Blue("→ PUT %s:\n", url)
 		}
-		requestJson, _ := json.MarshalIndent(request, "", "  ")
+		requestJson, _ := json.MarshalIndent(payload, "", "  ")
 		if verbose {
 			fmt.Printf("%s\n", requestJson)
 		}
 
-		response, err := marketplace.Update(url, request, cmd.Flag("basic-auth").Value.String())
-		if err != nil {
-			color.Red("%s", err)
-			os.Exit(1)
-		}
+		results.run("Update was successful", false, func() error {
+			var response marketplace.UpdateResponse
+			if err := client.Call(ctx, "PUT", url, payload, &response); err != nil {
+				return err
+			}
 
-		if verbose {
-			fmt.Printf("\nUpdate was successful:\n")
-			fmt.Printf("  Status:     %s\n\n", response.Status)
-		}
+			if verbose {
+				fmt.Printf("\nUpdate was successful:\n")
+				fmt.Printf("  Status:     %s\n\n", response.Status)
+			}
+			recordStatus(cmd, request.QuickNodeId, request.EndpointId, instanceUpdated, request.Plan)
+			return nil
+		})
 
-		color.Green("  ✓ Update was successful")
+		results.finish()
 	},
 }
 
@@ -97,4 +100,7 @@ func init() {
 	updateCmd.PersistentFlags().StringP("plan", "p", "discover", "The plan to provision the add-on for")
 	updateCmd.PersistentFlags().StringP("add-on-id", "i", "33", "The ID of the add-on to provision")
 	updateCmd.PersistentFlags().StringP("add-on-slug", "s", "myslug", "The slug of the add-on to provision")
+	addPayloadFlags(updateCmd)
+	addWhitelistFlags(updateCmd)
+	addInstanceFlag(updateCmd)
 }
@@ -0,0 +1,166 @@
+/*
+Copyright © 2023 QuickNode, Inc.
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// defaultReferer and defaultContractAddress are sent when the flags aren't set
+// and the chain has no address format of its own.
+const (
+	defaultReferer         = "https://quicknode.com"
+	defaultContractAddress = "0x4d224452801ACEd8B2F0aebE155379bb5D594381"
+)
+
+// addressFormat is the format of a chain's contract addresses.
+type addressFormat struct {
+	Name     string
+	Pattern  *regexp.Regexp
+	Example  string
+	Generate func(seed []byte) string
+}
+
+var (
+	evmAddresses = addressFormat{
+		Name:     "an EVM address (0x followed by 40 hex characters)",
+		Pattern:  regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`),
+		Example:  defaultContractAddress,
+		Generate: func(seed []byte) string { return "0x" + hex.EncodeToString(seed[:20]) },
+	}
+	solanaAddresses = addressFormat{
+		Name:     "a Solana address (32 to 44 base58 characters)",
+		Pattern:  regexp.MustCompile(`^[1-9A-HJ-NP-Za-km-z]{32,44}$`),
+		Example:  "So11111111111111111111111111111111111111112",
+		Generate: func(seed []byte) string { return base58(seed[:32]) },
+	}
+	tronAddresses = addressFormat{
+		Name:    "a Tron address (T followed by 33 base58 characters)",
+		Pattern: regexp.MustCompile(`^T[1-9A-HJ-NP-Za-km-z]{33}$`),
+		Example: "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t",
+		Generate: func(seed []byte) string {
+			payload := append([]byte{0x41}, seed[:20]...)
+			first := sha256.Sum256(payload)
+			checksum := sha256.Sum256(first[:])
+			return base58(append(payload, checksum[:4]...))
+		},
+	}
+	moveAddresses = addressFormat{
+		Name:     "a Move address (0x followed by up to 64 hex characters)",
+		Pattern:  regexp.MustCompile(`^0x[0-9a-fA-F]{1,64}$`),
+		Example:  "0x1",
+		Generate: func(seed []byte) string { return "0x" + hex.EncodeToString(seed) },
+	}
+)
+
+// chainAddressFormats maps chains to the format of their contract addresses.
+// Contract addresses aren't validated for other chains.
+var chainAddressFormats = map[string]addressFormat{
+	"ethereum":      evmAddresses,
+	"arbitrum":      evmAddresses,
+	"arbitrum-nova": evmAddresses,
+	"avalanche":     evmAddresses,
+	"base":          evmAddresses,
+	"blast":         evmAddresses,
+	"bsc":           evmAddresses,
+	"celo":          evmAddresses,
+	"fantom":        evmAddresses,
+	"gnosis":        evmAddresses,
+	"linea":         evmAddresses,
+	"mantle":        evmAddresses,
+	"optimism":      evmAddresses,
+	"polygon":       evmAddresses,
+	"polygon-zkevm": evmAddresses,
+	"scroll":        evmAddresses,
+	"zksync":        evmAddresses,
+	"solana":        solanaAddresses,
+	"tron":          tronAddresses,
+	"aptos":         moveAddresses,
+	"sui":           moveAddresses,
+}
+
+// addWhitelistFlags adds the flags setting the referers and contract addresses
+// sent in provision and update requests.
+func addWhitelistFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringArray("referer", []string{defaultReferer}, "A referer the endpoint is restricted to (can be repeated, pass --referer= to send an empty list)")
+	cmd.PersistentFlags().StringArray("contract-address", []string{}, "A contract address the endpoint is restricted to (can be repeated, pass --contract-address= to send an empty list). Defaults to a well-known contract of the chain")
+	cmd.PersistentFlags().Int("generated-referers", 0, "The number of generated referers to add to --referer, to test large lists")
+	cmd.PersistentFlags().Int("generated-contract-addresses", 0, "The number of generated contract addresses in the chain's format to add to --contract-address, to test large lists")
+	cmd.PersistentFlags().Bool("allow-invalid-contract-addresses", false, "Send contract addresses that don't match the chain's address format instead of refusing to")
+}
+
+// whitelist returns the referers and contract addresses to send for the
+// command's chain, exiting if a contract address doesn't match its format.
+// Generated values are the same on every call, so retried requests match.
+func whitelist(cmd *cobra.Command) ([]string, []string) {
+	chain := cmd.Flag("chain").Value.String()
+	format, known := chainAddressFormats[strings.ToLower(chain)]
+	if !known {
+		format = evmAddresses
+	}
+
+	referers, _ := cmd.Flags().GetStringArray("referer")
+	referers = nonEmpty(referers)
+	count, _ := cmd.Flags().GetInt("generated-referers")
+	for i := 0; i < count; i++ {
+		referers = append(referers, fmt.Sprintf("https://referer-%d.example.com", i+1))
+	}
+
+	var contractAddresses []string
+	if cmd.Flag("contract-address").Changed {
+		values, _ := cmd.Flags().GetStringArray("contract-address")
+		contractAddresses = nonEmpty(values)
+	} else {
+		contractAddresses = []string{format.Example}
+	}
+	count, _ = cmd.Flags().GetInt("generated-contract-addresses")
+	for i := 0; i < count; i++ {
+		seed := sha256.Sum256([]byte(fmt.Sprintf("qn-marketplace-cli contract %d", i+1)))
+		contractAddresses = append(contractAddresses, format.Generate(seed[:]))
+	}
+
+	allowInvalid := cmd.Flag("allow-invalid-contract-addresses").Value.String() == "true"
+	if known && !allowInvalid {
+		for _, address := range contractAddresses {
+			if !format.Pattern.MatchString(address) {
+				color.Red("Invalid --contract-address %q: expected %s for %s (use --allow-invalid-contract-addresses to send it anyway)", address, format.Name, chain)
+				os.Exit(1)
+			}
+		}
+	}
+	return referers, contractAddresses
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// base58 encodes data with the Bitcoin alphabet, used by Solana and Tron addresses.
+func base58(data []byte) string {
+	n := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+	var encoded []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		encoded = append(encoded, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		encoded = append(encoded, base58Alphabet[0])
+	}
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return string(encoded)
+}
@@ -1,16 +1,19 @@
 module github.com/quiknode-labs/qn-marketplace-cli
 
-go 1.18
+go 1.20
 
-require github.com/spf13/cobra v1.6.1
+require (
+	github.com/fatih/color v1.14.1
+	github.com/golang-jwt/jwt/v5 v5.0.0-rc.1
+	github.com/satori/go.uuid v1.2.0
+	github.com/spf13/cobra v1.6.1
+	github.com/spf13/pflag v1.0.5
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
-	github.com/fatih/color v1.14.1 // indirect
-	github.com/golang-jwt/jwt/v5 v5.0.0-rc.1 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.17 // indirect
-	github.com/satori/go.uuid v1.2.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
 	golang.org/x/sys v0.3.0 // indirect
 )
@@ -0,0 +1,52 @@
+package marketplace
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// CredentialVariant is a wrong set of credentials that a provisioning route must reject.
+type CredentialVariant struct {
+	Name string
+	// Authorization is the Authorization header sent, or empty to send none.
+	Authorization string
+}
+
+// CredentialVariants returns the wrong credentials that are sent to each
+// provisioning route, derived from the client's valid basic auth credentials.
+func (c *Client) CredentialVariants() []CredentialVariant {
+	username, password := "Aladdin", "open sesame"
+	if decoded, err := base64.StdEncoding.DecodeString(c.basicAuth); err == nil {
+		if u, p, ok := strings.Cut(string(decoded), ":"); ok {
+			username, password = u, p
+		}
+	}
+	basic := func(username string, password string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	}
+
+	return []CredentialVariant{
+		{Name: "no Authorization header", Authorization: ""},
+		{Name: "wrong password", Authorization: basic(username, password+"-wrong")},
+		{Name: "wrong username", Authorization: basic(username+"-wrong", password)},
+		{Name: "empty username and password", Authorization: basic("", "")},
+		{Name: "empty Basic credentials", Authorization: "Basic "},
+		{Name: "malformed base64", Authorization: "Basic %%not*base64%%"},
+		{Name: "credentials without a colon", Authorization: "Basic " + base64.StdEncoding.EncodeToString([]byte(username+password))},
+		{Name: "non-Basic scheme", Authorization: "Bearer " + c.basicAuth},
+	}
+}
+
+// RejectsCredentials sends variant to a provisioning route and reports whether
+// the add-on rejected it with a 401 or 403, along with the status code it got.
+func (c *Client) RejectsCredentials(ctx context.Context, url string, httpMethod string, variant CredentialVariant) (bool, int, error) {
+	res, err := c.send(ctx, httpMethod, url, ProvisionRequest{}, variant.Authorization)
+	if err != nil {
+		return false, 0, err
+	}
+
+	rejected := res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden
+	return rejected, res.StatusCode, nil
+}
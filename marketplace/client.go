@@ -0,0 +1,187 @@
+// Package marketplace implements the calls the QuickNode Marketplace makes to
+// an add-on. It never prints or exits: failures are returned as *HTTPError,
+// *DecodeError or *TransportError so callers can inspect them with errors.As.
+package marketplace
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is the per-request timeout used when none is configured.
+const DefaultTimeout = 30 * time.Second
+
+// Middleware wraps the client's transport, e.g. to log or record requests.
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// ClientOption configures a Client.
+type ClientOption func(*Client)
+
+// Client makes the calls the QuickNode Marketplace makes to an add-on. A single
+// Client should be shared by all the steps of a run so connections get reused.
+type Client struct {
+	baseURL    string
+	basicAuth  string
+	timeout    time.Duration
+	transport  http.RoundTripper
+	middleware []Middleware
+	httpClient *http.Client
+}
+
+// defaultTransport is shared by every client that does not bring its own.
+var defaultTransport http.RoundTripper = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   10,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: 1 * time.Second,
+}
+
+// WithBaseURL sets the URL that relative paths passed to the client are appended to.
+func WithBaseURL(baseURL string) ClientOption {
+	return func(c *Client) {
+		c.baseURL = baseURL
+	}
+}
+
+// WithBasicAuth sets the base64 encoded basic auth credentials sent to the provisioning API.
+func WithBasicAuth(credentials string) ClientOption {
+	return func(c *Client) {
+		c.basicAuth = credentials
+	}
+}
+
+// WithTimeout sets the timeout of each individual request. Zero disables it.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithTransport replaces the shared default transport.
+func WithTransport(transport http.RoundTripper) ClientOption {
+	return func(c *Client) {
+		c.transport = transport
+	}
+}
+
+// WithMiddleware adds middleware around the transport. The first middleware
+// given is the outermost one.
+func WithMiddleware(middleware ...Middleware) ClientOption {
+	return func(c *Client) {
+		c.middleware = append(c.middleware, middleware...)
+	}
+}
+
+func NewClient(opts ...ClientOption) *Client {
+	c := &Client{
+		timeout:   DefaultTimeout,
+		transport: defaultTransport,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	transport := c.transport
+	for i := len(c.middleware) - 1; i >= 0; i-- {
+		transport = c.middleware[i](transport)
+	}
+	c.httpClient = &http.Client{Transport: transport}
+
+	return c
+}
+
+// BaseURL returns the URL relative paths are resolved against.
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// BasicAuth returns the basic auth credentials sent to the provisioning API.
+func (c *Client) BasicAuth() string {
+	return c.basicAuth
+}
+
+// URL joins path to the client's base URL with exactly one slash between them,
+// keeping the query strings of both, e.g. "/provision" and "http://localhost:3000/"
+// give "http://localhost:3000/provision". Absolute URLs are returned as is.
+func (c *Client) URL(path string) string {
+	if c.baseURL == "" || strings.Contains(path, "://") {
+		return path
+	}
+	if path == "" {
+		return c.baseURL
+	}
+	base, err := neturl.Parse(c.baseURL)
+	if err != nil {
+		return strings.TrimRight(c.baseURL, "/") + "/" + strings.TrimLeft(path, "/")
+	}
+	// A path starting with // would be parsed as a host
+	ref, err := neturl.Parse("/" + strings.TrimLeft(path, "/"))
+	if err != nil {
+		return strings.TrimRight(c.baseURL, "/") + "/" + strings.TrimLeft(path, "/")
+	}
+
+	joined := strings.TrimRight(base.EscapedPath(), "/") + "/" + strings.TrimLeft(ref.EscapedPath(), "/")
+	if unescaped, err := neturl.PathUnescape(joined); err == nil {
+		base.Path, base.RawPath = unescaped, joined
+	}
+	if ref.RawQuery != "" {
+		query := base.Query()
+		for key, values := range ref.Query() {
+			query[key] = values
+		}
+		base.RawQuery = query.Encode()
+	}
+	return base.String()
+}
+
+// do sends a request and reads the whole response body, so that the
+// per-request timeout also covers reading the body. Failures to send the
+// request or read the response are returned as a *TransportError.
+func (c *Client) do(ctx context.Context, method string, url string, body io.Reader, header http.Header) (*http.Response, []byte, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	// Create the HTTP request
+	req, err := http.NewRequestWithContext(ctx, method, c.URL(url), body)
+	if err != nil {
+		return nil, nil, err
+	}
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		// The *url.Error returned by Do repeats the method and URL we already carry
+		if urlErr, ok := err.(*neturl.Error); ok {
+			err = urlErr.Err
+		}
+		return nil, nil, &TransportError{Method: method, URL: req.URL.String(), Err: err}
+	}
+	defer res.Body.Close()
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return res, nil, &TransportError{Method: method, URL: req.URL.String(), Err: err}
+	}
+
+	return res, resBody, nil
+}
@@ -0,0 +1,33 @@
+package marketplace
+
+import "testing"
+
+func TestClientURL(t *testing.T) {
+	tests := []struct {
+		name string
+		base string
+		path string
+		want string
+	}{
+		{"no base URL", "", "/provision", "/provision"},
+		{"no path", "http://localhost:3000/api", "", "http://localhost:3000/api"},
+		{"absolute URL", "http://localhost:3000/api", "https://other.example.com/provision", "https://other.example.com/provision"},
+		{"trailing slash", "http://localhost:3000/", "/provision", "http://localhost:3000/provision"},
+		{"no slash", "http://localhost:3000", "provision", "http://localhost:3000/provision"},
+		{"double slashes", "http://localhost:3000/api//", "//provision", "http://localhost:3000/api/provision"},
+		{"base path", "http://localhost:3000/provisioning", "/provision", "http://localhost:3000/provisioning/provision"},
+		{"base query", "http://localhost:3000/api?key=abc", "/provision", "http://localhost:3000/api/provision?key=abc"},
+		{"merged queries", "http://localhost:3000/api?key=abc", "/provision?debug=1", "http://localhost:3000/api/provision?debug=1&key=abc"},
+		{"path query wins", "http://localhost:3000/api?key=abc", "/provision?key=def", "http://localhost:3000/api/provision?key=def"},
+		{"escaped base path", "http://localhost:3000/a%2Fb/", "/provision", "http://localhost:3000/a%2Fb/provision"},
+		{"escaped path", "http://localhost:3000/api", "/accounts/a%2Fb%20c/provision", "http://localhost:3000/api/accounts/a%2Fb%20c/provision"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := NewClient(WithBaseURL(test.base))
+			if got := client.URL(test.path); got != test.want {
+				t.Errorf("URL(%q) with base %q = %q, want %q", test.path, test.base, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package marketplace
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBody is how much of a response body is included in error messages.
+const maxErrorBody = 512
+
+// HTTPError is returned when the add-on responds with an unexpected status code.
+type HTTPError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+func (e *HTTPError) Error() string {
+	msg := fmt.Sprintf("%s %s: HTTP Request failed with status code: %d", e.Method, e.URL, e.StatusCode)
+	if body := truncateBody(e.Body); body != "" {
+		msg += ": " + body
+	}
+	return msg
+}
+
+// DecodeError is returned when the add-on's response body is not the JSON we expected.
+type DecodeError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       []byte
+	Err        error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s %s: could not decode response (status code %d): %s: %s", e.Method, e.URL, e.StatusCode, e.Err, truncateBody(e.Body))
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// TransportError is returned when the request could not be sent or its response
+// could not be read, e.g. because the add-on is down or timed out.
+type TransportError struct {
+	Method string
+	URL    string
+	Err    error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether the request failed because a timeout or deadline was hit.
+func (e *TransportError) Timeout() bool {
+	t, ok := e.Err.(interface{ Timeout() bool })
+	return ok && t.Timeout()
+}
+
+func truncateBody(body []byte) string {
+	s := strings.TrimSpace(string(body))
+	if len(s) > maxErrorBody {
+		return s[:maxErrorBody] + "..."
+	}
+	return s
+}
+
+func newHTTPError(res *http.Response, body []byte) *HTTPError {
+	return &HTTPError{
+		Method:     res.Request.Method,
+		URL:        res.Request.URL.String(),
+		StatusCode: res.StatusCode,
+		Header:     res.Header,
+		Body:       body,
+	}
+}
+
+func newDecodeError(res *http.Response, body []byte, err error) *DecodeError {
+	return &DecodeError{
+		Method:     res.Request.Method,
+		URL:        res.Request.URL.String(),
+		StatusCode: res.StatusCode,
+		Body:       body,
+		Err:        err,
+	}
+}
+
+func (r *RawResponse) httpError() *HTTPError {
+	return &HTTPError{
+		Method:     r.Method,
+		URL:        r.URL,
+		StatusCode: r.StatusCode,
+		Header:     r.Header,
+		Body:       r.Body,
+	}
+}
+
+func (r *RawResponse) decodeError(err error) *DecodeError {
+	return &DecodeError{
+		Method:     r.Method,
+		URL:        r.URL,
+		StatusCode: r.StatusCode,
+		Body:       r.Body,
+		Err:        err,
+	}
+}
@@ -1,39 +1,24 @@
 package marketplace
 
 import (
-	"fmt"
-	"io/ioutil"
+	"context"
 	"net/http"
-	"os"
 )
 
-func Healthcheck(url string) (int, string, error) {
-	client := &http.Client{}
-
-	// Create the HTTP request
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		fmt.Println(err)
-		return 0, "", err
-	}
-
-	res, err := client.Do(req)
+func (c *Client) Healthcheck(ctx context.Context, url string) (int, string, error) {
+	res, body, err := c.do(ctx, "GET", url, nil, nil)
 	if err != nil {
-		fmt.Println(err)
 		return 0, "", err
 	}
-	defer res.Body.Close()
-
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-	bodyStr := fmt.Sprintf("%s", body)
+	bodyStr := string(body)
 
 	if res.StatusCode != http.StatusOK {
-		return res.StatusCode, bodyStr, fmt.Errorf("HTTP Request failed with status code: %d", res.StatusCode)
+		return res.StatusCode, bodyStr, newHTTPError(res, body)
 	} else {
 		return res.StatusCode, bodyStr, nil
 	}
 }
+
+func Healthcheck(url string) (int, string, error) {
+	return NewClient().Healthcheck(context.Background(), url)
+}
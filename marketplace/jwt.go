@@ -0,0 +1,327 @@
+package marketplace
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTAlgorithms are the algorithms SSO tokens can be signed with.
+var JWTAlgorithms = []string{"HS256", "HS384", "HS512", "RS256"}
+
+// DefaultJWTTTL is how long SSO tokens are valid for when no TTL is configured.
+const DefaultJWTTTL = 10 * time.Minute
+
+// UserClaims are the names of the claims carrying the user's details.
+var UserClaims = []string{"name", "email", "organization_name", "quicknode_id"}
+
+// JWTOption configures the SSO tokens GetJWT signs.
+type JWTOption func(*jwtConfig)
+
+type jwtConfig struct {
+	algorithm  string
+	privateKey *rsa.PrivateKey
+	publicKey  *rsa.PublicKey
+	ttl        time.Duration
+	claims     map[string]interface{}
+	claimNames map[string]string
+}
+
+// WithAlgorithm sets the algorithm tokens are signed with, one of JWTAlgorithms.
+// RS256 tokens are signed with the key set by WithPrivateKey instead of the secret.
+func WithAlgorithm(algorithm string) JWTOption {
+	return func(c *jwtConfig) {
+		c.algorithm = algorithm
+	}
+}
+
+// WithPrivateKey sets the key RS256 tokens are signed with.
+func WithPrivateKey(key *rsa.PrivateKey) JWTOption {
+	return func(c *jwtConfig) {
+		c.privateKey = key
+	}
+}
+
+// WithPublicKey sets the key RS256 tokens are verified with, instead of the
+// public key of the WithPrivateKey key.
+func WithPublicKey(key *rsa.PublicKey) JWTOption {
+	return func(c *jwtConfig) {
+		c.publicKey = key
+	}
+}
+
+// WithTTL sets how long tokens are valid for.
+func WithTTL(ttl time.Duration) JWTOption {
+	return func(c *jwtConfig) {
+		c.ttl = ttl
+	}
+}
+
+// WithClaims adds claims to the tokens, e.g. iss or aud, overriding the user's
+// claims of the same name. iat and exp are always set, see WithTTL.
+func WithClaims(claims map[string]interface{}) JWTOption {
+	return func(c *jwtConfig) {
+		for name, value := range claims {
+			c.claims[name] = value
+		}
+	}
+}
+
+// WithClaimNames renames the claims carrying the user's details, from one of
+// UserClaims to the name to send. Claims renamed to "" are left out.
+func WithClaimNames(names map[string]string) JWTOption {
+	return func(c *jwtConfig) {
+		for claim, name := range names {
+			c.claimNames[claim] = name
+		}
+	}
+}
+
+func newJWTConfig(opts []JWTOption) (*jwtConfig, error) {
+	c := &jwtConfig{algorithm: "HS256", ttl: DefaultJWTTTL, claims: map[string]interface{}{}, claimNames: map[string]string{}}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	known := false
+	for _, algorithm := range JWTAlgorithms {
+		known = known || algorithm == c.algorithm
+	}
+	if !known {
+		return nil, fmt.Errorf("unsupported JWT algorithm %q, expected one of %s", c.algorithm, strings.Join(JWTAlgorithms, ", "))
+	}
+	for claim := range c.claimNames {
+		if !isUserClaim(claim) {
+			return nil, fmt.Errorf("unknown claim %q, expected one of %s", claim, strings.Join(UserClaims, ", "))
+		}
+	}
+	return c, nil
+}
+
+func isUserClaim(claim string) bool {
+	for _, name := range UserClaims {
+		if name == claim {
+			return true
+		}
+	}
+	return false
+}
+
+// name returns the name a user claim is sent as, "" if it is left out.
+func (c *jwtConfig) name(claim string) string {
+	if name, ok := c.claimNames[claim]; ok {
+		return name
+	}
+	return claim
+}
+
+// method returns the signing method and key of the tokens.
+func (c *jwtConfig) method(secretKey string) (jwt.SigningMethod, interface{}, error) {
+	if c.algorithm == "RS256" {
+		if c.privateKey == nil {
+			return nil, nil, fmt.Errorf("a private key is needed to sign RS256 tokens")
+		}
+		return jwt.SigningMethodRS256, c.privateKey, nil
+	}
+	return jwt.GetSigningMethod(c.algorithm), []byte(secretKey), nil
+}
+
+// verificationKey returns the key the tokens' signature is checked with.
+func (c *jwtConfig) verificationKey(secretKey string) (interface{}, error) {
+	if c.algorithm != "RS256" {
+		if secretKey == "" {
+			return nil, fmt.Errorf("a secret is needed to verify %s tokens, any token signed with an empty one would pass", c.algorithm)
+		}
+		return []byte(secretKey), nil
+	}
+	if c.publicKey != nil {
+		return c.publicKey, nil
+	}
+	if c.privateKey != nil {
+		return &c.privateKey.PublicKey, nil
+	}
+	return nil, fmt.Errorf("a public or private key is needed to verify RS256 tokens")
+}
+
+// userClaims returns the claims of a token for user issued at issuedAt.
+func (c *jwtConfig) userClaims(user User, issuedAt time.Time) jwt.MapClaims {
+	claims := jwt.MapClaims{}
+	values := map[string]string{
+		"name":              user.Name,
+		"email":             user.Email,
+		"organization_name": user.OrganizationName,
+		"quicknode_id":      user.QuicknodeID,
+	}
+	for claim, value := range values {
+		if name := c.name(claim); name != "" {
+			claims[name] = value
+		}
+	}
+	for name, value := range c.claims {
+		claims[name] = value
+	}
+	claims["iat"] = issuedAt.Unix()
+	claims["exp"] = issuedAt.Add(c.ttl).Unix()
+	return claims
+}
+
+// GetJWT returns the SSO token the marketplace sends for user: by default a
+// HS256 token signed with secretKey, valid for 10 minutes.
+func GetJWT(secretKey string, user User, opts ...JWTOption) (string, error) {
+	c, err := newJWTConfig(opts)
+	if err != nil {
+		return "", err
+	}
+	method, key, err := c.method(secretKey)
+	if err != nil {
+		return "", err
+	}
+	return signJWT(method, key, c.userClaims(user, time.Now()))
+}
+
+// VerifyJWT checks a token the way an add-on should and returns its claims:
+// it must be signed with the configured algorithm and key, carry iat and exp
+// no further apart than the TTL, be valid now, and carry the user claims and
+// the configured extra claims. email and quicknode_id can't be empty.
+func VerifyJWT(token string, secretKey string, opts ...JWTOption) (jwt.MapClaims, error) {
+	c, err := newJWTConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	key, err := c.verificationKey(secretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return key, nil
+	}, jwt.WithValidMethods([]string{c.algorithm}), jwt.WithIssuedAt())
+	if err != nil {
+		return claims, err
+	}
+
+	issuedAt, err := claims.GetIssuedAt()
+	if err != nil || issuedAt == nil {
+		return claims, fmt.Errorf("the token has no valid iat claim")
+	}
+	expiresAt, err := claims.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		return claims, fmt.Errorf("the token has no valid exp claim")
+	}
+	if lifetime := expiresAt.Sub(issuedAt.Time); lifetime > c.ttl {
+		return claims, fmt.Errorf("the token is valid for %s, longer than %s", lifetime, c.ttl)
+	}
+
+	for _, claim := range UserClaims {
+		name := c.name(claim)
+		if name == "" {
+			continue
+		}
+		value, ok := claims[name].(string)
+		if !ok {
+			return claims, fmt.Errorf("the token has no %s claim, or it isn't a string", name)
+		}
+		if value == "" && (claim == "email" || claim == "quicknode_id") {
+			return claims, fmt.Errorf("the token's %s claim is empty", name)
+		}
+	}
+	for name, expected := range c.claims {
+		if fmt.Sprint(claims[name]) != fmt.Sprint(expected) {
+			return claims, fmt.Errorf("the token's %s claim is %v, expected %v", name, claims[name], expected)
+		}
+	}
+	return claims, nil
+}
+
+// InvalidJWT is a token a dashboard must reject, named after what is wrong
+// with it, e.g. "an expired token".
+type InvalidJWT struct {
+	Name  string
+	Token string
+}
+
+// GetInvalidJWTs returns tokens for user that are expired, not valid yet,
+// signed with the wrong key, unsigned, missing claims or truncated, configured
+// like GetJWT's otherwise.
+func GetInvalidJWTs(secretKey string, user User, opts ...JWTOption) ([]InvalidJWT, error) {
+	c, err := newJWTConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	method, key, err := c.method(secretKey)
+	if err != nil {
+		return nil, err
+	}
+	valid, err := signJWT(method, key, c.userClaims(user, now))
+	if err != nil {
+		return nil, err
+	}
+
+	type invalidJWT struct {
+		name   string
+		method jwt.SigningMethod
+		key    interface{}
+		claims jwt.MapClaims
+	}
+	notBefore := c.userClaims(user, now)
+	notBefore["nbf"] = now.Add(time.Hour).Unix()
+	invalid := []invalidJWT{
+		{"an expired token", method, key, c.userClaims(user, now.Add(-time.Hour-c.ttl))},
+		{"a token issued in the future", method, key, c.userClaims(user, now.Add(time.Hour))},
+		{"a token that is not valid yet", method, key, notBefore},
+		{"an unsigned token (alg: none)", jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, c.userClaims(user, now)},
+	}
+
+	if c.algorithm == "RS256" {
+		wrongKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		invalid = append(invalid, invalidJWT{"a token signed with the wrong key", method, wrongKey, c.userClaims(user, now)})
+
+		// Dashboards that trust the alg header verify this one with the public key as an HMAC secret
+		publicKey, err := x509.MarshalPKIXPublicKey(&c.privateKey.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})
+		invalid = append(invalid, invalidJWT{"a HS256 token signed with the public key", jwt.SigningMethodHS256, publicPEM, c.userClaims(user, now)})
+	} else {
+		invalid = append(invalid, invalidJWT{"a token signed with the wrong secret", method, []byte("wrong-" + secretKey), c.userClaims(user, now)})
+	}
+
+	for _, claim := range []string{"email", "quicknode_id"} {
+		if name := c.name(claim); name != "" {
+			claims := c.userClaims(user, now)
+			delete(claims, name)
+			invalid = append(invalid, invalidJWT{"a token without " + name, method, key, claims})
+		}
+	}
+
+	var tokens []InvalidJWT
+	for _, t := range invalid {
+		token, err := signJWT(t.method, t.key, t.claims)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, InvalidJWT{Name: t.name, Token: token})
+	}
+
+	signature := strings.LastIndex(valid, ".")
+	return append(tokens,
+		InvalidJWT{Name: "a token without a signature", Token: valid[:signature+1]},
+		InvalidJWT{Name: "a truncated token", Token: valid[:len(valid)-10]},
+	), nil
+}
+
+func signJWT(method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) (string, error) {
+	return jwt.NewWithClaims(method, claims).SignedString(key)
+}
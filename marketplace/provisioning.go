@@ -2,9 +2,8 @@ package marketplace
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
-	"io/ioutil"
 	"net/http"
 )
 
@@ -70,30 +69,11 @@ type DeprovisionResponse struct {
 	Status string `json:"status"`
 }
 
-func RequiresBasicAuth(url string, httpMethod string) (bool, error) {
-	client := &http.Client{}
-
-	payload := ProvisionRequest{}
-
-	// Convert the payload to JSON
-	payloadBuf := new(bytes.Buffer)
-	json.NewEncoder(payloadBuf).Encode(payload)
-
-	// Create the HTTP request
-	req, err := http.NewRequest(httpMethod, url, payloadBuf)
+func (c *Client) RequiresBasicAuth(ctx context.Context, url string, httpMethod string) (bool, error) {
+	res, err := c.send(ctx, httpMethod, url, ProvisionRequest{}, "")
 	if err != nil {
-		fmt.Println(err)
 		return false, err
 	}
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("X-QN-TESTING", "true")
-
-	res, err := client.Do(req)
-	if err != nil {
-		fmt.Println(err)
-		return false, err
-	}
-	defer res.Body.Close()
 
 	if res.StatusCode == http.StatusUnauthorized {
 		return true, nil
@@ -102,174 +82,125 @@ func RequiresBasicAuth(url string, httpMethod string) (bool, error) {
 	}
 }
 
-func Provision(url string, payload ProvisionRequest, basicAuth string) (ProvisionResponse, error) {
-	client := &http.Client{}
-
-	// Convert the payload to JSON
-	payloadBuf := new(bytes.Buffer)
-	json.NewEncoder(payloadBuf).Encode(payload)
-
-	// Create the HTTP request
-	req, err := http.NewRequest("POST", url, payloadBuf)
-	if err != nil {
-		fmt.Println(err)
-		return ProvisionResponse{}, err
-	}
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", "Basic "+basicAuth)
-	req.Header.Add("X-QN-TESTING", "true")
-
-	res, err := client.Do(req)
-	if err != nil {
-		fmt.Println(err)
+func (c *Client) Provision(ctx context.Context, url string, payload ProvisionRequest) (ProvisionResponse, error) {
+	var response ProvisionResponse
+	if err := c.provisioningCall(ctx, "POST", url, payload, &response); err != nil {
 		return ProvisionResponse{}, err
 	}
-	defer res.Body.Close()
+	return response, nil
+}
 
-	if res.StatusCode != http.StatusOK {
-		return ProvisionResponse{}, fmt.Errorf("HTTP Request failed with status code: %d", res.StatusCode)
+func (c *Client) Update(ctx context.Context, url string, payload UpdateRequest) (UpdateResponse, error) {
+	var response UpdateResponse
+	if err := c.provisioningCall(ctx, "PUT", url, payload, &response); err != nil {
+		return UpdateResponse{}, err
 	}
+	return response, nil
+}
 
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		fmt.Println(err)
-		return ProvisionResponse{}, err
+func (c *Client) Deactivate(ctx context.Context, url string, payload DeactivateRequest) (DeactivateResponse, error) {
+	var response DeactivateResponse
+	if err := c.provisioningCall(ctx, "DELETE", url, payload, &response); err != nil {
+		return DeactivateResponse{}, err
 	}
+	return response, nil
+}
 
-	var response ProvisionResponse
-	if err := json.Unmarshal([]byte(body), &response); err != nil {
-		fmt.Println(err)
-		return ProvisionResponse{}, err
+func (c *Client) Deprovision(ctx context.Context, url string, payload DeprovisionRequest) (DeprovisionResponse, error) {
+	var response DeprovisionResponse
+	if err := c.provisioningCall(ctx, "DELETE", url, payload, &response); err != nil {
+		return DeprovisionResponse{}, err
 	}
-
 	return response, nil
 }
 
-func Update(url string, payload UpdateRequest, basicAuth string) (UpdateResponse, error) {
-	client := &http.Client{}
+// RawResponse is a response from the add-on, whatever its status code.
+type RawResponse struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Send sends payload as JSON to a provisioning route, authenticated with the
+// client's basic auth, and returns the response whatever its status code.
+// payload can be one of the request structs or any other JSON value.
+func (c *Client) Send(ctx context.Context, httpMethod string, url string, payload interface{}) (*RawResponse, error) {
+	return c.send(ctx, httpMethod, url, payload, "Basic "+c.basicAuth)
+}
 
+// send sends payload as JSON with the given Authorization header, which is
+// left out when empty.
+func (c *Client) send(ctx context.Context, httpMethod string, url string, payload interface{}, authorization string) (*RawResponse, error) {
 	// Convert the payload to JSON
 	payloadBuf := new(bytes.Buffer)
-	json.NewEncoder(payloadBuf).Encode(payload)
-
-	// Create the HTTP request
-	req, err := http.NewRequest("PUT", url, payloadBuf)
-	if err != nil {
-		fmt.Println(err)
-		return UpdateResponse{}, err
+	if err := json.NewEncoder(payloadBuf).Encode(payload); err != nil {
+		return nil, err
 	}
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", "Basic "+basicAuth)
-	req.Header.Add("X-QN-TESTING", "true")
 
-	res, err := client.Do(req)
-	if err != nil {
-		fmt.Println(err)
-		return UpdateResponse{}, err
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		return UpdateResponse{}, fmt.Errorf("HTTP Request failed with status code: %d", res.StatusCode)
+	header := http.Header{}
+	header.Add("Content-Type", "application/json")
+	if authorization != "" {
+		header.Add("Authorization", authorization)
 	}
+	header.Add("X-QN-TESTING", "true")
 
-	body, err := ioutil.ReadAll(res.Body)
+	res, body, err := c.do(ctx, httpMethod, url, payloadBuf, header)
 	if err != nil {
-		fmt.Println(err)
-		return UpdateResponse{}, err
+		return nil, err
 	}
 
-	var response UpdateResponse
-	if err := json.Unmarshal([]byte(body), &response); err != nil {
-		fmt.Println(err)
-		return UpdateResponse{}, err
-	}
-
-	return response, nil
+	return &RawResponse{
+		Method:     res.Request.Method,
+		URL:        res.Request.URL.String(),
+		StatusCode: res.StatusCode,
+		Header:     res.Header,
+		Body:       body,
+	}, nil
 }
 
-func Deactivate(url string, payload DeactivateRequest, basicAuth string) (DeactivateResponse, error) {
-	client := &http.Client{}
-
-	// Convert the payload to JSON
-	payloadBuf := new(bytes.Buffer)
-	json.NewEncoder(payloadBuf).Encode(payload)
-
-	// Create the HTTP request
-	req, err := http.NewRequest("DELETE", url, payloadBuf)
-	if err != nil {
-		fmt.Println(err)
-		return DeactivateResponse{}, err
-	}
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", "Basic "+basicAuth)
-	req.Header.Add("X-QN-TESTING", "true")
+// Call sends any JSON payload to a provisioning route, e.g. one with fields
+// the request structs don't have, and decodes a 200 response into response.
+func (c *Client) Call(ctx context.Context, httpMethod string, url string, payload interface{}, response interface{}) error {
+	return c.provisioningCall(ctx, httpMethod, url, payload, response)
+}
 
-	res, err := client.Do(req)
+// provisioningCall sends an authenticated provisioning request and decodes
+// the JSON response into response. Any status other than 200 is an *HTTPError.
+func (c *Client) provisioningCall(ctx context.Context, httpMethod string, url string, payload interface{}, response interface{}) error {
+	res, err := c.Send(ctx, httpMethod, url, payload)
 	if err != nil {
-		fmt.Println(err)
-		return DeactivateResponse{}, err
+		return err
 	}
-	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return DeactivateResponse{}, fmt.Errorf("HTTP Request failed with status code: %d", res.StatusCode)
+		return res.httpError()
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		fmt.Println(err)
-		return DeactivateResponse{}, err
+	if err := json.Unmarshal(res.Body, response); err != nil {
+		return res.decodeError(err)
 	}
 
-	var response DeactivateResponse
-	if err := json.Unmarshal([]byte(body), &response); err != nil {
-		fmt.Println(err)
-		return DeactivateResponse{}, err
-	}
-
-	return response, nil
+	return nil
 }
 
-func Deprovision(url string, payload DeprovisionRequest, basicAuth string) (DeprovisionResponse, error) {
-	client := &http.Client{}
-
-	// Convert the payload to JSON
-	payloadBuf := new(bytes.Buffer)
-	json.NewEncoder(payloadBuf).Encode(payload)
-
-	// Create the HTTP request
-	req, err := http.NewRequest("DELETE", url, payloadBuf)
-	if err != nil {
-		fmt.Println(err)
-		return DeprovisionResponse{}, err
-	}
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", "Basic "+basicAuth)
-	req.Header.Add("X-QN-TESTING", "true")
-
-	res, err := client.Do(req)
-	if err != nil {
-		fmt.Println(err)
-		return DeprovisionResponse{}, err
-	}
-	defer res.Body.Close()
+func RequiresBasicAuth(url string, httpMethod string) (bool, error) {
+	return NewClient().RequiresBasicAuth(context.Background(), url, httpMethod)
+}
 
-	if res.StatusCode != http.StatusOK {
-		return DeprovisionResponse{}, fmt.Errorf("HTTP Request failed with status code: %d", res.StatusCode)
-	}
+func Provision(url string, payload ProvisionRequest, basicAuth string) (ProvisionResponse, error) {
+	return NewClient(WithBasicAuth(basicAuth)).Provision(context.Background(), url, payload)
+}
 
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		fmt.Println(err)
-		return DeprovisionResponse{}, err
-	}
+func Update(url string, payload UpdateRequest, basicAuth string) (UpdateResponse, error) {
+	return NewClient(WithBasicAuth(basicAuth)).Update(context.Background(), url, payload)
+}
 
-	var response DeprovisionResponse
-	if err := json.Unmarshal([]byte(body), &response); err != nil {
-		fmt.Println(err)
-		return DeprovisionResponse{}, err
-	}
+func Deactivate(url string, payload DeactivateRequest, basicAuth string) (DeactivateResponse, error) {
+	return NewClient(WithBasicAuth(basicAuth)).Deactivate(context.Background(), url, payload)
+}
 
-	return response, nil
+func Deprovision(url string, payload DeprovisionRequest, basicAuth string) (DeprovisionResponse, error) {
+	return NewClient(WithBasicAuth(basicAuth)).Deprovision(context.Background(), url, payload)
 }
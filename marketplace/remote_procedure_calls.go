@@ -1,7 +1,66 @@
 package marketplace
 
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
 type RPCRequest struct {
 	Method string        `json:"method"`
 	Params []interface{} `json:"params"`
 	ID     string        `json:"id"`
 }
+
+// InstanceHeaders identify the provisioned instance an RPC or REST call is made for.
+type InstanceHeaders struct {
+	QuickNodeId string
+	EndpointId  string
+	Chain       string
+	Network     string
+}
+
+func (h InstanceHeaders) header() http.Header {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("X-QUICKNODE-ID", h.QuickNodeId)
+	header.Set("X-INSTANCE-ID", h.EndpointId)
+	header.Set("X-QN-CHAIN", h.Chain)
+	header.Set("X-QN-NETWORK", h.Network)
+	header.Add("X-QN-TESTING", "true")
+	return header
+}
+
+// RPC makes a JSON-RPC call to the add-on and returns the status code and decoded response body.
+func (c *Client) RPC(ctx context.Context, url string, request RPCRequest, instance InstanceHeaders) (int, interface{}, error) {
+	// Encode the request object into a JSON string
+	reqBody, err := json.Marshal(request)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return c.call(ctx, "POST", url, bytes.NewBuffer(reqBody), instance)
+}
+
+// REST makes a REST call to the add-on and returns the status code and decoded response body.
+func (c *Client) REST(ctx context.Context, httpMethod string, url string, body string, instance InstanceHeaders) (int, interface{}, error) {
+	return c.call(ctx, httpMethod, url, strings.NewReader(body), instance)
+}
+
+func (c *Client) call(ctx context.Context, httpMethod string, url string, body io.Reader, instance InstanceHeaders) (int, interface{}, error) {
+	res, resBody, err := c.do(ctx, httpMethod, url, body, instance.header())
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// Decode the response body into an interface{} object
+	var decoded interface{}
+	if err := json.Unmarshal(resBody, &decoded); err != nil {
+		return res.StatusCode, nil, newDecodeError(res, resBody, err)
+	}
+
+	return res.StatusCode, decoded, nil
+}
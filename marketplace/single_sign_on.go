@@ -1,11 +1,10 @@
 package marketplace
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"os"
-	"time"
+	"net/http/cookiejar"
 
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -14,7 +13,7 @@ type User struct {
 	Name             string `json:"name"`
 	Email            string `json:"email"`
 	OrganizationName string `json:"organization_name"`
-	QuicknodeID      string `json:"quicknode-id"`
+	QuicknodeID      string `json:"quicknode_id"`
 }
 
 type JWTClaims struct {
@@ -22,52 +21,100 @@ type JWTClaims struct {
 	jwt.RegisteredClaims
 }
 
-func GetJWT(secretKey string, user User) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
+// DashboardResponse is the page a dashboard URL ends up on, after redirects.
+type DashboardResponse struct {
+	StatusCode int
+	URL        string
+	Header     http.Header
+	Body       string
+	Redirects  []Redirect
+}
 
-	claims := token.Claims.(jwt.MapClaims)
-	claims["iat"] = time.Now().Unix()
-	claims["exp"] = time.Now().Add(10 * time.Minute).Unix()
-	claims["name"] = user.Name
-	claims["email"] = user.Email
-	claims["organization_name"] = user.OrganizationName
-	claims["quicknode_id"] = user.QuicknodeID
+// Redirect is a redirect followed on the way to a dashboard page.
+type Redirect struct {
+	StatusCode int
+	URL        string
+	Location   string
+	Header     http.Header
+}
 
-	tokenString, err := token.SignedString([]byte(secretKey))
-	if err != nil {
-		return "", err
-	}
+// maxRedirects is how many redirects a dashboard session follows, like browsers do.
+const maxRedirects = 10
 
-	return tokenString, nil
+// DashboardSession is a browser-like session on a dashboard: cookies set by a
+// response, including redirects, are sent with the following requests.
+type DashboardSession struct {
+	client    *Client
+	redirects []Redirect
 }
 
-func OpenDashboard(url string) (int, string, error) {
-	client := &http.Client{}
+// NewDashboardSession starts a session without any cookies.
+func (c *Client) NewDashboardSession() (*DashboardSession, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	s := &DashboardSession{}
+	client := *c
+	httpClient := *c.httpClient
+	httpClient.Jar = jar
+	httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		s.redirects = append(s.redirects, Redirect{
+			StatusCode: req.Response.StatusCode,
+			URL:        via[len(via)-1].URL.String(),
+			Location:   req.URL.String(),
+			Header:     req.Response.Header,
+		})
+		return nil
+	}
+	client.httpClient = &httpClient
+	s.client = &client
+	return s, nil
+}
 
-	// Create the HTTP request
-	req, err := http.NewRequest("GET", url, nil)
+// Visit opens a dashboard URL, following redirects, and returns the page it
+// ends up on whatever its status code.
+func (s *DashboardSession) Visit(ctx context.Context, url string) (*DashboardResponse, error) {
+	s.redirects = nil
+	res, body, err := s.client.do(ctx, "GET", url, nil, nil)
 	if err != nil {
-		fmt.Println(err)
-		return 0, "", err
+		return nil, err
 	}
+	return &DashboardResponse{
+		StatusCode: res.StatusCode,
+		URL:        res.Request.URL.String(),
+		Header:     res.Header,
+		Body:       string(body),
+		Redirects:  s.redirects,
+	}, nil
+}
 
-	res, err := client.Do(req)
+// VisitDashboard opens a dashboard URL in a new session, see DashboardSession.Visit.
+func (c *Client) VisitDashboard(ctx context.Context, url string) (*DashboardResponse, error) {
+	session, err := c.NewDashboardSession()
 	if err != nil {
-		fmt.Println(err)
-		return 0, "", err
+		return nil, err
 	}
-	defer res.Body.Close()
+	return session.Visit(ctx, url)
+}
 
-	body, err := ioutil.ReadAll(res.Body)
+func (c *Client) OpenDashboard(ctx context.Context, url string) (int, string, error) {
+	res, body, err := c.do(ctx, "GET", url, nil, nil)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return 0, "", err
 	}
-	bodyStr := fmt.Sprintf("%s", body)
+	bodyStr := string(body)
 
 	if res.StatusCode != http.StatusOK {
-		return res.StatusCode, bodyStr, fmt.Errorf("HTTP Request failed with status code: %d", res.StatusCode)
+		return res.StatusCode, bodyStr, newHTTPError(res, body)
 	} else {
 		return res.StatusCode, bodyStr, nil
 	}
 }
+
+func OpenDashboard(url string) (int, string, error) {
+	return NewClient().OpenDashboard(context.Background(), url)
+}